@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhengchun/syndfeed"
+)
+
+// archives keeps every item of the source feeds, it is used only if
+// -archive-dir is set.
+var archives *archiveStore
+
+// archivePageSize is the number of items of an archive document.
+const archivePageSize = 50
+
+// maxLoadedArchives is the number of archives kept in memory, the least
+// recently used ones are dropped and read from their files again.
+const maxLoadedArchives = 64
+
+// historyNamespace is the namespace of Feed Paging and Archiving(RFC 5005).
+const historyNamespace = "http://purl.org/syndication/history/1.0"
+
+var errPageNotFound = errors.New("Page not found")
+
+// archiveStore keeps the items of every source feed in dir, one file per
+// source feed, up to size items which are not older than retention.
+type archiveStore struct {
+	dir       string
+	size      int
+	retention time.Duration
+
+	mu    sync.Mutex
+	feeds map[string]*feedArchive
+}
+
+// feedArchive is the items of a source feed in the order they were
+// archived. The sequence number of an item never changes, so are the
+// archive documents.
+type feedArchive struct {
+	Source string          `json:"source"`
+	Next   int             `json:"next"`
+	Items  []*archivedItem `json:"items"`
+
+	used time.Time
+}
+
+// archivedItem is an item with its extension, except the extension
+// elements which are output only while the item is in the source feed.
+type archivedItem struct {
+	Seq         int            `json:"seq"`
+	Key         string         `json:"key"`
+	Item        *syndfeed.Item `json:"item"`
+	IsPermaLink string         `json:"is_perma_link,omitempty"`
+	Enclosures  []*enclosure   `json:"enclosures,omitempty"`
+	Image       string         `json:"image,omitempty"`
+	Archived    time.Time      `json:"archived"`
+}
+
+func newArchiveStore(dir string, size int, retention time.Duration) *archiveStore {
+	return &archiveStore{
+		dir:       dir,
+		size:      size,
+		retention: retention,
+		feeds:     make(map[string]*feedArchive),
+	}
+}
+
+// itemKey returns the key of item in the archive.
+func itemKey(item *syndfeed.Item) string {
+	if item.Id != "" {
+		return item.Id
+	}
+	if len(item.Links) > 0 {
+		return item.Links[0].URL
+	}
+	return item.Title
+}
+
+func (s *archiveStore) filename(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns the archive of source, which is read from the file if it
+// is not in memory.
+func (s *archiveStore) load(source string) *feedArchive {
+	if a, ok := s.feeds[source]; ok {
+		a.used = time.Now()
+		return a
+	}
+	a := &feedArchive{Source: source}
+	if b, err := ioutil.ReadFile(s.filename(source)); err == nil {
+		if err := json.Unmarshal(b, a); err != nil {
+			logrus.Warnf("archive: read %s failed. %s", source, err)
+			a = &feedArchive{Source: source}
+		}
+	}
+	a.used = time.Now()
+	s.feeds[source] = a
+	s.evict()
+	return a
+}
+
+// evict drops the least recently used archives from memory, they are
+// saved whenever they are modified.
+func (s *archiveStore) evict() {
+	for len(s.feeds) > maxLoadedArchives {
+		var oldest *feedArchive
+		for _, a := range s.feeds {
+			if oldest == nil || a.used.Before(oldest.used) {
+				oldest = a
+			}
+		}
+		delete(s.feeds, oldest.Source)
+	}
+}
+
+func (s *archiveStore) save(a *feedArchive) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(s.filename(a.Source), b, 0644); err != nil {
+		logrus.Warnf("archive: write %s failed. %s", a.Source, err)
+	}
+}
+
+// Load creates the archive directory, the archives are loaded when they
+// are requested.
+func (s *archiveStore) Load() error {
+	return os.MkdirAll(s.dir, 0755)
+}
+
+// Add archives the items of the feed of source. An item already in the
+// archive is updated, and keeps its sequence number.
+func (s *archiveStore) Add(source string, feed *fullFeed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.load(source)
+	index := make(map[string]int, len(a.Items))
+	for i, v := range a.Items {
+		index[v.Key] = i
+	}
+	now := time.Now()
+	var changed bool
+	// the items of a feed are newest first, so archive the oldest first.
+	for i := len(feed.Items) - 1; i >= 0; i-- {
+		item := *feed.Items[i]
+		ext := feed.ext(feed.Items[i])
+		v := &archivedItem{
+			Key:         itemKey(&item),
+			Item:        &item,
+			IsPermaLink: ext.isPermaLink,
+			Enclosures:  ext.enclosures,
+			Image:       ext.image,
+			Archived:    now,
+		}
+		j, ok := index[v.Key]
+		if !ok {
+			v.Seq = a.Next
+			a.Next++
+			index[v.Key] = len(a.Items)
+			a.Items = append(a.Items, v)
+			changed = true
+			continue
+		}
+		last := a.Items[j]
+		// keep the full text if the article failed this time.
+		if !ext.fulltext || item.Content == last.Item.Content {
+			continue
+		}
+		v.Seq, v.Archived = last.Seq, last.Archived
+		// the archived items are never modified, they are replaced.
+		a.Items[j] = v
+		changed = true
+	}
+	if s.prune(a, now) || changed {
+		s.save(a)
+	}
+}
+
+// prune removes the items which are over retention or size.
+func (s *archiveStore) prune(a *feedArchive, now time.Time) bool {
+	n := len(a.Items)
+	if s.retention > 0 {
+		items := a.Items[:0]
+		for _, v := range a.Items {
+			if now.Sub(v.Archived) <= s.retention {
+				items = append(items, v)
+			}
+		}
+		a.Items = items
+	}
+	if s.size > 0 && len(a.Items) > s.size {
+		a.Items = append([]*archivedItem(nil), a.Items[len(a.Items)-s.size:]...)
+	}
+	return len(a.Items) != n
+}
+
+// View sets the items of feed to the page of opts, newest first, and the
+// links of RFC 5005 to the other pages. link returns the URL of the page
+// by its parameter, key is empty for the subscription document.
+func (s *archiveStore) View(feed *fullFeed, opts *feedOptions, link func(key string, n int) string) error {
+	s.mu.Lock()
+	a := s.load(opts.source)
+	list := append([]*archivedItem(nil), a.Items...)
+	// the archive documents from first to complete have items, the
+	// older ones are pruned.
+	complete := a.Next / archivePageSize
+	first := complete
+	if len(list) > 0 && list[0].Seq/archivePageSize < first {
+		first = list[0].Seq / archivePageSize
+	}
+	s.mu.Unlock()
+
+	live := make(map[string]*syndfeed.Item, len(feed.Items))
+	for _, item := range feed.Items {
+		live[itemKey(item)] = item
+	}
+	var items []*syndfeed.Item
+	add := func(v *archivedItem) {
+		if item, ok := live[v.Key]; ok {
+			items = append(items, item)
+			return
+		}
+		item := *v.Item
+		feed.items[&item] = &itemExtension{
+			isPermaLink: v.IsPermaLink,
+			enclosures:  v.Enclosures,
+			image:       v.Image,
+		}
+		items = append(items, &item)
+	}
+	newLink := func(rel, key string, n int) *syndfeed.Link {
+		return &syndfeed.Link{RelType: rel, URL: link(key, n)}
+	}
+
+	if opts.archive >= 0 {
+		if opts.archive < first || opts.archive >= complete {
+			return errPageNotFound
+		}
+		from, to := opts.archive*archivePageSize, (opts.archive+1)*archivePageSize
+		for i := len(list) - 1; i >= 0; i-- {
+			if list[i].Seq >= from && list[i].Seq < to {
+				add(list[i])
+			}
+		}
+		feed.archive = true
+		feed.archiveLinks = []*syndfeed.Link{newLink("current", "", 0)}
+		if opts.archive > first {
+			feed.archiveLinks = append(feed.archiveLinks, newLink("prev-archive", "archive", opts.archive-1))
+		}
+		if opts.archive+1 < complete {
+			feed.archiveLinks = append(feed.archiveLinks, newLink("next-archive", "archive", opts.archive+1))
+		}
+		feed.Items = items
+		return nil
+	}
+
+	from := (opts.page - 1) * opts.count
+	if from > 0 && from >= len(list) {
+		return errPageNotFound
+	}
+	for i := len(list) - 1 - from; i >= 0 && len(items) < opts.count; i-- {
+		add(list[i])
+	}
+	feed.archiveLinks = nil
+	if opts.page > 1 {
+		feed.archiveLinks = append(feed.archiveLinks, newLink("first", "", 0))
+		if opts.page == 2 {
+			feed.archiveLinks = append(feed.archiveLinks, newLink("previous", "", 0))
+		} else {
+			feed.archiveLinks = append(feed.archiveLinks, newLink("previous", "page", opts.page-1))
+		}
+	}
+	if from+opts.count < len(list) {
+		feed.archiveLinks = append(feed.archiveLinks, newLink("next", "page", opts.page+1))
+	}
+	if complete > first {
+		feed.archiveLinks = append(feed.archiveLinks, newLink("prev-archive", "archive", complete-1))
+	}
+	feed.Items = items
+	return nil
+}
+
+// historyLink returns the function to build the URLs of the pages of the
+// feed requested by r, always in the query form.
+func historyLink(r *http.Request, opts *feedOptions) func(key string, n int) string {
+	q := r.URL.Query()
+	if strings.Count(r.URL.Path, "/") > 1 {
+		q = url.Values{"url": {opts.source}}
+	}
+	q.Del("page")
+	q.Del("archive")
+	route := strings.SplitN(r.URL.Path[1:], "/", 2)[0]
+	base := baseURL(r) + "/" + route + "?"
+	return func(key string, n int) string {
+		v := make(url.Values, len(q)+1)
+		for k, s := range q {
+			v[k] = s
+		}
+		if key != "" {
+			v.Set(key, strconv.Itoa(n))
+		}
+		return base + v.Encode()
+	}
+}
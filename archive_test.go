@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhengchun/syndfeed"
+)
+
+// testFeed returns a feed of the items from..to-1, newest first.
+func testFeed(from, to int) *fullFeed {
+	f := &fullFeed{Feed: new(syndfeed.Feed), items: make(map[*syndfeed.Item]*itemExtension)}
+	for i := to - 1; i >= from; i-- {
+		f.Items = append(f.Items, &syndfeed.Item{Id: strconv.Itoa(i), Title: "item " + strconv.Itoa(i)})
+	}
+	return f
+}
+
+func archiveLinks(feed *fullFeed) map[string]string {
+	links := make(map[string]string)
+	for _, v := range feed.archiveLinks {
+		links[v.RelType] = v.URL
+	}
+	return links
+}
+
+func testLink(key string, n int) string {
+	if key == "" {
+		return "current"
+	}
+	return key + "=" + strconv.Itoa(n)
+}
+
+func TestArchiveView(t *testing.T) {
+	s := newArchiveStore(t.TempDir(), 0, 0)
+	const source = "https://example.com/feed"
+	for i := 0; i < 120; i += 10 {
+		s.Add(source, testFeed(i, i+10))
+	}
+
+	tests := []struct {
+		page, archive int
+		count         int
+		first, last   string
+		links         map[string]string
+	}{
+		{1, -1, 10, "119", "110", map[string]string{"next": "page=2", "prev-archive": "archive=1"}},
+		{2, -1, 10, "109", "100", map[string]string{"first": "current", "previous": "current", "next": "page=3", "prev-archive": "archive=1"}},
+		{12, -1, 10, "9", "0", map[string]string{"first": "current", "previous": "page=11", "prev-archive": "archive=1"}},
+		{1, 0, 50, "49", "0", map[string]string{"current": "current", "next-archive": "archive=1"}},
+		{1, 1, 50, "99", "50", map[string]string{"current": "current", "prev-archive": "archive=0"}},
+	}
+	for _, tt := range tests {
+		feed := testFeed(0, 0)
+		opts := &feedOptions{source: source, count: 10, page: tt.page, archive: tt.archive}
+		if err := s.View(feed, opts, testLink); err != nil {
+			t.Fatalf("page %d archive %d: %s", tt.page, tt.archive, err)
+		}
+		if len(feed.Items) != tt.count || feed.Items[0].Id != tt.first || feed.Items[len(feed.Items)-1].Id != tt.last {
+			t.Errorf("page %d archive %d: got %d items %s..%s", tt.page, tt.archive, len(feed.Items), feed.Items[0].Id, feed.Items[len(feed.Items)-1].Id)
+		}
+		links := archiveLinks(feed)
+		if len(links) != len(tt.links) {
+			t.Errorf("page %d archive %d: got links %v, want %v", tt.page, tt.archive, links, tt.links)
+		}
+		for rel, want := range tt.links {
+			if links[rel] != want {
+				t.Errorf("page %d archive %d: got %s %q, want %q", tt.page, tt.archive, rel, links[rel], want)
+			}
+		}
+	}
+
+	// the incomplete archive document and the page after the last.
+	for _, opts := range []*feedOptions{
+		{source: source, count: 10, page: 1, archive: 2},
+		{source: source, count: 10, page: 13, archive: -1},
+	} {
+		if err := s.View(testFeed(0, 0), opts, testLink); err != errPageNotFound {
+			t.Errorf("page %d archive %d: got %v, want %v", opts.page, opts.archive, err, errPageNotFound)
+		}
+	}
+}
+
+func TestArchivePruned(t *testing.T) {
+	s := newArchiveStore(t.TempDir(), 60, 0)
+	const source = "https://example.com/feed"
+	for i := 0; i < 150; i += 10 {
+		s.Add(source, testFeed(i, i+10))
+	}
+	// the items 90..149 are kept, so the archive document 0 is gone.
+	feed := testFeed(0, 0)
+	if err := s.View(feed, &feedOptions{source: source, count: 10, page: 1, archive: -1}, testLink); err != nil {
+		t.Fatal(err)
+	}
+	if v := archiveLinks(feed)["prev-archive"]; v != "archive=2" {
+		t.Errorf("got prev-archive %q, want archive=2", v)
+	}
+	if err := s.View(testFeed(0, 0), &feedOptions{source: source, archive: 0}, testLink); err != errPageNotFound {
+		t.Errorf("got %v for the pruned archive document", err)
+	}
+	feed = testFeed(0, 0)
+	if err := s.View(feed, &feedOptions{source: source, archive: 1}, testLink); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 10 || feed.Items[0].Id != "99" {
+		t.Errorf("got %d items of the partly pruned archive document", len(feed.Items))
+	}
+	if _, ok := archiveLinks(feed)["prev-archive"]; ok {
+		t.Error("the pruned archive document is linked")
+	}
+
+	// all items are over retention.
+	s.retention = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	s.Add(source, testFeed(0, 0))
+	feed = testFeed(0, 0)
+	if err := s.View(feed, &feedOptions{source: source, count: 10, page: 1, archive: -1}, testLink); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 0 || len(feed.archiveLinks) != 0 {
+		t.Errorf("got %d items and links %v", len(feed.Items), archiveLinks(feed))
+	}
+}
+
+func TestArchiveEvict(t *testing.T) {
+	s := newArchiveStore(t.TempDir(), 0, 0)
+	for i := 0; i <= maxLoadedArchives; i++ {
+		s.Add("https://example.com/"+strconv.Itoa(i), testFeed(i, i+1))
+	}
+	if len(s.feeds) != maxLoadedArchives {
+		t.Fatalf("got %d archives in memory, want %d", len(s.feeds), maxLoadedArchives)
+	}
+	// the dropped archive is read from its file.
+	feed := testFeed(0, 0)
+	if err := s.View(feed, &feedOptions{source: "https://example.com/0", count: 10, page: 1, archive: -1}, testLink); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 1 || feed.Items[0].Id != "0" {
+		t.Fatalf("got %d items of the dropped archive", len(feed.Items))
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/zhengchun/syndfeed"
+)
+
+func atomDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func outputAtomPersons(x *xmlWriter, name string, persons []*syndfeed.Person) {
+	for _, v := range persons {
+		x.start(name)
+		x.element("name", v.Name)
+		x.elementIf("uri", v.URL)
+		x.elementIf("email", v.Email)
+		x.end(name)
+	}
+}
+
+func outputAtomLinks(x *xmlWriter, links []*syndfeed.Link) {
+	for _, v := range links {
+		attrs := []string{"href", v.URL}
+		if v.RelType != "" {
+			attrs = append(attrs, "rel", v.RelType)
+		}
+		if v.MediaType != "" {
+			attrs = append(attrs, "type", v.MediaType)
+		}
+		if v.Title != "" {
+			attrs = append(attrs, "title", v.Title)
+		}
+		x.element("link", "", attrs...)
+	}
+}
+
+func outputAtom(w io.Writer, feed *fullFeed) error {
+	x := newXMLWriter(w)
+	x.procInst("xml", `version="1.0" encoding="UTF-8"`)
+	attrs := []string{"xmlns", "http://www.w3.org/2005/Atom"}
+	var declared []string
+	if feed.archive {
+		attrs = append(attrs, "xmlns:fh", historyNamespace)
+		declared = append(declared, "fh")
+	}
+	if feed.failed() {
+		attrs = append(attrs, "xmlns:rss2full", statusNamespace)
+		declared = append(declared, "rss2full")
+	}
+	attrs = append(attrs, feed.namespaces(declared...)...)
+	if _, ok := feed.Namespace["media"]; !ok {
+		attrs = append(attrs, "xmlns:media", "http://search.yahoo.com/mrss/")
+	}
+	if feed.Language != "" {
+		attrs = append(attrs, "xml:lang", feed.Language)
+	}
+	x.start("feed", attrs...)
+	// id
+	id := feed.Id
+	if id == "" && len(feed.Links) > 0 {
+		id = feed.Links[0].URL
+	}
+	x.element("id", id)
+	x.element("title", feed.Title)
+	x.elementIf("subtitle", feed.Description)
+	// updated is required
+	feedUpdated := feed.LastUpdatedTime
+	if feedUpdated.IsZero() {
+		feedUpdated = lastModified(feed.Feed)
+	}
+	if feedUpdated.IsZero() {
+		feedUpdated = feed.parsed
+	}
+	if feedUpdated.IsZero() {
+		feedUpdated = time.Now()
+	}
+	x.element("updated", atomDate(feedUpdated))
+	var links []*syndfeed.Link
+	for _, v := range feed.Links {
+		// the links to the source feed itself and its pages.
+		if !contains(sourceLinkRels, v.RelType) {
+			links = append(links, v)
+		}
+	}
+	outputAtomLinks(x, links)
+	outputAtomLinks(x, feed.archiveLinks)
+	if feed.archive {
+		x.element("fh:archive", "")
+	}
+	outputAtomPersons(x, "author", feed.Authors)
+	outputAtomPersons(x, "contributor", feed.Contributors)
+	for _, v := range feed.Categories {
+		x.element("category", "", "term", v)
+	}
+	x.element("generator", "rss2full", "uri", "https://github.com/feedocean/rss2full")
+	x.elementIf("logo", feed.ImageURL)
+	x.elementIf("rights", feed.Copyright)
+	for _, v := range feed.elements {
+		x.node(v)
+	}
+	for _, item := range feed.Items {
+		x.start("entry")
+		// id
+		id := item.Id
+		if id == "" && len(item.Links) > 0 {
+			id = item.Links[0].URL
+		}
+		x.element("id", id)
+		x.element("title", item.Title)
+		// updated is required
+		updated := item.LastUpdatedTime
+		if updated.IsZero() {
+			updated = item.PublishDate
+		}
+		if updated.IsZero() {
+			updated = feedUpdated
+		}
+		x.element("updated", atomDate(updated))
+		if !item.PublishDate.IsZero() {
+			x.element("published", atomDate(item.PublishDate))
+		}
+		outputAtomLinks(x, item.Links)
+		// the enclosures of Atom feeds are in the links already.
+		for _, v := range feed.ext(item).enclosures {
+			attrs := []string{"rel", "enclosure", "href", v.URL}
+			if v.Type != "" {
+				attrs = append(attrs, "type", v.Type)
+			}
+			if v.Length != "" {
+				attrs = append(attrs, "length", v.Length)
+			}
+			x.element("link", "", attrs...)
+		}
+		outputAtomPersons(x, "author", item.Authors)
+		outputAtomPersons(x, "contributor", item.Contributors)
+		for _, v := range item.Categories {
+			x.element("category", "", "term", v)
+		}
+		x.elementIf("rights", item.Copyright)
+		x.elementIf("summary", item.Summary, "type", "html")
+		x.elementIf("content", item.Content, "type", "html")
+		for _, v := range feed.ext(item).elements {
+			x.node(v)
+		}
+		if v := feed.ext(item).thumbnail(); v != "" {
+			x.element("media:thumbnail", "", "url", v)
+		}
+		if f := feed.ext(item).failure; f != nil {
+			x.element("rss2full:failure", f.message, f.attrs()...)
+		}
+		x.end("entry")
+	}
+	x.end("feed")
+	return x.flush()
+}
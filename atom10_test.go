@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputAtomUpdated(t *testing.T) {
+	feed, err := parseFeed([]byte(`<rss version="2.0"><channel><title>t</title>
+<item><title>a</title><link>https://example.com/a</link></item></channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := outputAtom(&buf, feed.clone()); err != nil {
+		t.Fatal(err)
+	}
+	want := "<updated>" + atomDate(feed.parsed) + "</updated>"
+	if n := strings.Count(buf.String(), want); n != 2 {
+		t.Fatalf("got %d of %s, want 2 in %s", n, want, buf.String())
+	}
+
+	// the feed which is not parsed, such as an archive document.
+	feed.parsed = time.Time{}
+	buf.Reset()
+	if err := outputAtom(&buf, feed); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "0001-01-01") {
+		t.Fatalf("got zero updated in %s", buf.String())
+	}
+}
+
+func TestOutputAtomEnclosure(t *testing.T) {
+	feed, err := parseFeed([]byte(`<rss version="2.0"><channel><title>t</title>
+<item><title>a</title><enclosure url="https://example.com/a.mp3" length="1024" type="audio/mpeg"/></item>
+<item><title>b</title><enclosure url="https://example.com/b.mp3" length="" type=""/></item></channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := outputAtom(&buf, feed); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<link rel="enclosure" href="https://example.com/a.mp3" type="audio/mpeg" length="1024"></link>`,
+		`<link rel="enclosure" href="https://example.com/b.mp3"></link>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("no %s in %s", want, buf.String())
+		}
+	}
+}
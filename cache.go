@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// articles is the process-wide cache of extracted articles.
+var articles = newArticleCache("", 24*time.Hour, 1000)
+
+// articleCache caches the extracted content of articles so that the
+// same link is not fetched again on every poll of its feed. Entries
+// are written through to dir(if not empty) and survive restarts.
+type articleCache struct {
+	dir  string
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	Key string `json:"key"`
+	article
+	Created time.Time `json:"created"`
+}
+
+func newArticleCache(dir string, ttl time.Duration, size int) *articleCache {
+	return &articleCache{
+		dir:     dir,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// articleKey returns a cache key of the item, the GUID is part of key
+// when present, so a re-published item gets extracted again.
+func articleKey(link, id string) string {
+	if id == "" || id == link {
+		return link
+	}
+	return link + "#" + id
+}
+
+func (c *articleCache) filename(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *articleCache) expired(e *cacheEntry) bool {
+	return c.ttl > 0 && time.Since(e.Created) > c.ttl
+}
+
+// Load loads all unexpired entries from the cache directory.
+func (c *articleCache) Load() error {
+	if c.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		name := filepath.Join(c.dir, fi.Name())
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			logrus.Warnf("cache: read %s failed. %s", name, err)
+			continue
+		}
+		var e cacheEntry
+		if err := json.Unmarshal(b, &e); err != nil || c.expired(&e) {
+			os.Remove(name)
+			continue
+		}
+		c.entries[e.Key] = &e
+	}
+	c.evict()
+	logrus.Infof("cache: loaded %d articles from %s", len(c.entries), c.dir)
+	return nil
+}
+
+// Get returns the cached article of key.
+func (c *articleCache) Get(key string) (*article, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if c.expired(e) {
+		c.remove(key)
+		return nil, false
+	}
+	a := e.article
+	return &a, true
+}
+
+// Set stores the article of key.
+func (c *articleCache) Set(key string, a *article) {
+	if c.size <= 0 {
+		return
+	}
+	e := &cacheEntry{Key: key, article: *a, Created: time.Now()}
+	c.mu.Lock()
+	c.entries[key] = e
+	c.evict()
+	c.mu.Unlock()
+
+	if c.dir == "" {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(c.filename(key), b, 0644); err != nil {
+		logrus.Warnf("cache: write %s failed. %s", key, err)
+	}
+}
+
+func (c *articleCache) remove(key string) {
+	delete(c.entries, key)
+	if c.dir != "" {
+		os.Remove(c.filename(key))
+	}
+}
+
+// evict removes the oldest entries until the cache fits in size.
+func (c *articleCache) evict() {
+	for len(c.entries) > c.size {
+		var oldest *cacheEntry
+		for _, e := range c.entries {
+			if oldest == nil || e.Created.Before(oldest.Created) {
+				oldest = e
+			}
+		}
+		c.remove(oldest.Key)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestArticleKey(t *testing.T) {
+	tests := []struct {
+		link, id string
+		want     string
+	}{
+		{"http://example.com/a", "", "http://example.com/a"},
+		{"http://example.com/a", "http://example.com/a", "http://example.com/a"},
+		{"http://example.com/a", "tag:example.com,2018:1", "http://example.com/a#tag:example.com,2018:1"},
+	}
+	for _, tt := range tests {
+		if got := articleKey(tt.link, tt.id); got != tt.want {
+			t.Errorf("articleKey(%q, %q) = %q, want %q", tt.link, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestArticleCacheExpired(t *testing.T) {
+	c := newArticleCache("", time.Hour, 10)
+	c.Set("a", &article{Content: "a"})
+	c.Set("b", &article{Content: "b"})
+	c.entries["b"].Created = time.Now().Add(-2 * time.Hour)
+	if a, ok := c.Get("a"); !ok || a.Content != "a" {
+		t.Errorf("Get(a) = %v, %v", a, ok)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("the expired article is returned")
+	}
+	if _, ok := c.entries["b"]; ok {
+		t.Error("the expired article is kept")
+	}
+}
+
+func TestArticleCacheEvict(t *testing.T) {
+	c := newArticleCache("", 0, 3)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		c.Set(strconv.Itoa(i), &article{})
+		c.entries[strconv.Itoa(i)].Created = now.Add(time.Duration(i-5) * time.Second)
+	}
+	if len(c.entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(c.entries))
+	}
+	for _, key := range []string{"2", "3", "4"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s is evicted", key)
+		}
+	}
+
+	c = newArticleCache("", 0, 0)
+	c.Set("a", &article{})
+	if _, ok := c.Get("a"); ok {
+		t.Error("an article is cached with size 0")
+	}
+}
+
+func TestArticleCacheLoad(t *testing.T) {
+	dir := t.TempDir()
+	c := newArticleCache(dir, time.Hour, 10)
+	c.Set("a", &article{Content: "a", Title: "A"})
+
+	c = newArticleCache(dir, time.Hour, 10)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := c.Get("a"); !ok || a.Content != "a" || a.Title != "A" {
+		t.Errorf("Get(a) = %v, %v", a, ok)
+	}
+
+	// the entry is older than the ttl of the new cache.
+	time.Sleep(10 * time.Millisecond)
+	c = newArticleCache(dir, time.Millisecond, 10)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("got %d expired entries", len(c.entries))
+	}
+	c = newArticleCache(dir, time.Hour, 10)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("the expired article is not removed from the directory")
+	}
+}
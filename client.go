@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultUserAgent is the User-Agent of the requests if the client config
+// does not set one.
+var defaultUserAgent = "Mozilla/5.0 (compatible; rss2full/" + Version + "; +https://github.com/feedocean/rss2full)"
+
+// clientConfig is the config of the upstream requests, loaded from the
+// JSON file of -client-config:
+//
+//	{
+//	  "user_agent": "Mozilla/5.0 ...",
+//	  "hosts": [
+//	    {
+//	      "match": ".example.com",
+//	      "headers": {"Accept-Language": "en"},
+//	      "cookies": "example.cookies.txt",
+//	      "proxy": "socks5://127.0.0.1:1080",
+//	      "tls": {"ca_file": "ca.pem", "min_version": "1.2"}
+//	    }
+//	  ]
+//	}
+//
+// The match of a host is a host name, a name with a leading dot which
+// matches its subdomains too, or a pattern such as *.example.com. The
+// first host which matches is used. The relative files are relative to
+// the config file.
+type clientConfig struct {
+	UserAgent string        `json:"user_agent"`
+	Hosts     []*hostConfig `json:"hosts"`
+}
+
+type hostConfig struct {
+	Match   string            `json:"match"`
+	Headers map[string]string `json:"headers"`
+	Cookies string            `json:"cookies"`
+	Proxy   string            `json:"proxy"`
+	TLS     *tlsConfig        `json:"tls"`
+}
+
+type tlsConfig struct {
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	MinVersion         string `json:"min_version"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// loadClientConfig loads the client config from file, an empty file is
+// the default config.
+func loadClientConfig(file string) (*clientConfig, error) {
+	conf := new(clientConfig)
+	if file == "" {
+		return conf, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeStrict(b, conf); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	conf.resolve(filepath.Dir(file))
+	return conf, nil
+}
+
+// resolve makes the relative files of c relative to dir.
+func (c *clientConfig) resolve(dir string) {
+	for _, h := range c.Hosts {
+		h.Cookies = relativeTo(dir, h.Cookies)
+		if h.TLS != nil {
+			h.TLS.CAFile = relativeTo(dir, h.TLS.CAFile)
+			h.TLS.CertFile = relativeTo(dir, h.TLS.CertFile)
+			h.TLS.KeyFile = relativeTo(dir, h.TLS.KeyFile)
+		}
+	}
+}
+
+func relativeTo(dir, file string) string {
+	if file == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(dir, file)
+}
+
+// clients is the transport of httpClient, which is replaced when the
+// config is reloaded. The requests in progress keep the old one.
+var clients = new(clientTransport)
+
+type clientTransport struct {
+	v atomic.Value
+}
+
+func (t *clientTransport) set(ht *hostTransport) {
+	old, _ := t.v.Load().(*hostTransport)
+	t.v.Store(ht)
+	if old != nil {
+		old.closeIdleConnections()
+	}
+}
+
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ht, _ := t.v.Load().(*hostTransport)
+	if ht == nil {
+		return nil, errors.New("client is not configured")
+	}
+	return ht.RoundTrip(req)
+}
+
+// hostTransport sends the requests with the profile of the host, so the
+// redirects to another host get the headers, cookies and proxy of it.
+type hostTransport struct {
+	userAgent string
+	profiles  []*hostProfile
+	// def is the transport of the hosts which match no profile.
+	def http.RoundTripper
+}
+
+type hostProfile struct {
+	match     string
+	header    http.Header
+	jar       http.CookieJar
+	proxied   bool
+	transport http.RoundTripper
+}
+
+// newHostTransport creates the transport of conf.
+func newHostTransport(conf *clientConfig) (*hostTransport, error) {
+	t := &hostTransport{
+		userAgent: conf.UserAgent,
+		def:       newTransport(),
+	}
+	if t.userAgent == "" {
+		t.userAgent = defaultUserAgent
+	}
+	for _, h := range conf.Hosts {
+		p, err := newHostProfile(h)
+		if err != nil {
+			return nil, fmt.Errorf("client: host %s: %s", h.Match, err)
+		}
+		t.profiles = append(t.profiles, p)
+	}
+	return t, nil
+}
+
+func newHostProfile(h *hostConfig) (*hostProfile, error) {
+	match := strings.ToLower(strings.TrimSpace(h.Match))
+	if match == "" {
+		return nil, fmt.Errorf("match is empty")
+	}
+	if _, err := path.Match(match, ""); err != nil {
+		return nil, err
+	}
+	p := &hostProfile{match: match, header: make(http.Header)}
+	for k, v := range h.Headers {
+		p.header.Set(k, v)
+	}
+	if h.Cookies != "" {
+		jar, err := loadCookies(h.Cookies)
+		if err != nil {
+			return nil, err
+		}
+		p.jar = jar
+	}
+	if h.Proxy == "" && h.TLS == nil {
+		return p, nil
+	}
+	tr := newTransport()
+	if h.Proxy != "" {
+		u, err := url.Parse(h.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("proxy scheme is not supported(%s)", u.Scheme)
+		}
+		tr.Proxy = http.ProxyURL(u)
+		// the proxy is trusted, it may be on the local network. The
+		// addresses of the hosts are checked before the requests.
+		tr.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		p.proxied = true
+	}
+	if h.TLS != nil {
+		c, err := h.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		tr.TLSClientConfig = c
+	}
+	p.transport = tr
+	return p, nil
+}
+
+func (c *tlsConfig) config() (*tls.Config, error) {
+	conf := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+	}
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("tls version is not supported(%s)", c.MinVersion)
+		}
+		conf.MinVersion = v
+	}
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%s has no certificate", c.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// matches reports whether the profile is used for host.
+func (p *hostProfile) matches(host string) bool {
+	if strings.HasPrefix(p.match, ".") {
+		return host == p.match[1:] || strings.HasSuffix(host, p.match)
+	}
+	ok, _ := path.Match(p.match, host)
+	return ok
+}
+
+func (t *hostTransport) closeIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	for _, tr := range append([]http.RoundTripper{t.def}, t.transports()...) {
+		if v, ok := tr.(closeIdler); ok {
+			v.CloseIdleConnections()
+		}
+	}
+}
+
+func (t *hostTransport) transports() []http.RoundTripper {
+	var list []http.RoundTripper
+	for _, p := range t.profiles {
+		if p.transport != nil {
+			list = append(list, p.transport)
+		}
+	}
+	return list
+}
+
+func (t *hostTransport) profile(host string) *hostProfile {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, p := range t.profiles {
+		if p.matches(host) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	p := t.profile(req.URL.Hostname())
+	if p == nil {
+		return t.def.RoundTrip(req)
+	}
+	for k, v := range p.header {
+		req.Header[k] = v
+	}
+	if p.jar != nil {
+		for _, c := range p.jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+	}
+	transport := t.def
+	if p.transport != nil {
+		transport = p.transport
+	}
+	if p.proxied {
+		// the dialer of the proxy can't check the address of the host.
+		if err := checkHostAddrs(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if p.jar != nil {
+		if cookies := resp.Cookies(); len(cookies) > 0 {
+			p.jar.SetCookies(req.URL, cookies)
+		}
+	}
+	return resp, nil
+}
+
+// checkHostAddrs resolves the host of req, and checks its addresses.
+func checkHostAddrs(req *http.Request) error {
+	host := req.URL.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return guard.checkIP(ip)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(req.Context(), host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := guard.checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCookies loads a cookie jar from a cookies.txt file of the Netscape
+// format, which is exported by the browsers and curl:
+//
+//	domain	include-subdomains	path	secure	expires	name	value
+func loadCookies(file string) (http.CookieJar, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	jar, _ := cookiejar.New(nil)
+	now := time.Now()
+	var n int
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		httpOnly := strings.HasPrefix(s, "#HttpOnly_")
+		if httpOnly {
+			s = s[len("#HttpOnly_"):]
+		} else if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		fields := strings.Split(s, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%s:%d: expected 7 fields, got %d", file, line, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid expires(%s)", file, line, fields[4])
+		}
+		c := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+			if c.Expires.Before(now) {
+				continue
+			}
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		// the host-only cookie has no domain.
+		if strings.EqualFold(fields[1], "TRUE") {
+			c.Domain = domain
+		}
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: domain, Path: c.Path}, []*http.Cookie{c})
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	logrus.Infof("client: loaded %d cookies from %s", n, file)
+	return jar, nil
+}
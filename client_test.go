@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostProfileMatches(t *testing.T) {
+	tests := []struct {
+		match string
+		host  string
+		want  bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "www.example.com", false},
+		{".example.com", "example.com", true},
+		{".example.com", "a.b.example.com", true},
+		{".example.com", "notexample.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "example.com", false},
+		// * of path.Match matches the dots too.
+		{"*.example.com", "a.b.example.com", true},
+		{"news.*.com", "news.example.com", true},
+	}
+	for _, tt := range tests {
+		p, err := newHostProfile(&hostConfig{Match: tt.match})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.matches(tt.host); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.match, tt.host, got, tt.want)
+		}
+	}
+	for _, match := range []string{"", " ", "[a-"} {
+		if _, err := newHostProfile(&hostConfig{Match: match}); err == nil {
+			t.Errorf("match %q is valid", match)
+		}
+	}
+}
+
+func TestLoadCookies(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	lines := []string{
+		"# Netscape HTTP Cookie File",
+		"",
+		fmt.Sprintf(".example.com\tTRUE\t/\tFALSE\t%d\tsession\ts1", expires),
+		"www.example.org\tFALSE\t/\tFALSE\t0\thost\th1",
+		fmt.Sprintf("#HttpOnly_example.net\tFALSE\t/\tTRUE\t%d\tsecure\tx1", expires),
+		"example.com\tFALSE\t/\tFALSE\t1\texpired\te1",
+	}
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := loadCookies(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/a", "session=s1"},
+		{"http://www.example.com/", "session=s1"},
+		{"http://www.example.org/", "host=h1"},
+		{"http://sub.www.example.org/", ""},
+		{"http://example.net/", ""},
+		{"https://example.net/", "secure=x1"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		var list []string
+		for _, c := range jar.Cookies(u) {
+			list = append(list, c.Name+"="+c.Value)
+		}
+		if got := strings.Join(list, "; "); got != tt.want {
+			t.Errorf("cookies of %s = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"example.com\tTRUE\t/\tFALSE\t0\tname", "example.com\tTRUE\t/\tFALSE\tnever\tname\tvalue"} {
+		if err := ioutil.WriteFile(file, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadCookies(file); err == nil || !strings.Contains(err.Error(), ":1:") {
+			t.Errorf("loadCookies(%q) error = %v", s, err)
+		}
+	}
+}
+
+func TestLoadClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "client.json")
+	conf := `{"hosts": [{"match": "example.com", "cookies": "cookies.txt", "tls": {"ca_file": "/etc/ca.pem"}}]}`
+	if err := ioutil.WriteFile(file, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadClientConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := c.Hosts[0]; h.Cookies != filepath.Join(dir, "cookies.txt") || h.TLS.CAFile != "/etc/ca.pem" {
+		t.Errorf("got cookies %s, ca_file %s", h.Cookies, h.TLS.CAFile)
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"user-agent": "x"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadClientConfig(file); err == nil {
+		t.Error("the unknown field is accepted")
+	}
+	if _, err := newHostTransport(&clientConfig{Hosts: []*hostConfig{{Match: "a", Proxy: "ftp://proxy"}}}); err == nil {
+		t.Error("the ftp proxy is accepted")
+	}
+	if _, err := newHostTransport(&clientConfig{Hosts: []*hostConfig{{Match: "a", TLS: &tlsConfig{MinVersion: "1.4"}}}}); err == nil {
+		t.Error("TLS 1.4 is accepted")
+	}
+}
+
+func TestHostTransport(t *testing.T) {
+	guard.update("", "", true)
+	t.Cleanup(func() { guard.update("", "", false) })
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s|%s", r.UserAgent(), r.Header.Get("Accept-Language"), r.Header.Get("Cookie"))
+	}))
+	defer ts.Close()
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := ioutil.WriteFile(file, []byte("127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\ts1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ht, err := newHostTransport(&clientConfig{
+		UserAgent: "test/1.0",
+		Hosts:     []*hostConfig{{Match: "127.0.0.1", Headers: map[string]string{"Accept-Language": "en"}, Cookies: file}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ht.closeIdleConnections()
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{ts.URL, "test/1.0|en|session=s1"},
+		{strings.Replace(ts.URL, "127.0.0.1", "localhost", 1), "test/1.0||"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		resp, err := ht.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != tt.want {
+			t.Errorf("GET %s got %q, want %q", tt.url, b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// configSections are the options of each section of the config file, by
+// the names of the command-line flags:
+//
+//	{
+//	  "server": {"p": 8088, "item-count": 20, "base-url": "https://rss.example.com"},
+//	  "fetch": {"feed-timeout": "20s", "deny-hosts": "ads.example.com"},
+//	  "cache": {"cache-dir": "./cache", "cache-ttl": "48h"},
+//	  "extraction": {"rules-dir": "./rules", "max-pages": 3},
+//	  "client": {"user_agent": "Mozilla/5.0 ...", "hosts": [...]},
+//	  "feeds": [
+//	    {"url": "https://example.com/rss.xml", "count": 25, "selector": ["//article"]}
+//	  ]
+//	}
+//
+// client is the same as the file of -client-config, and feeds are the
+// defaults of the feeds, see feedConfig.
+var configSections = map[string][]string{
+	"server": {
+		"a", "p", "base-url", "max-age", "item-count", "max-item-count", "failure-notice",
+		"refresh-interval", "subscription-idle", "subscriptions-file",
+		"image-proxy", "image-key", "image-max-width", "drain-timeout", "shutdown-delay",
+	},
+	"fetch": {
+		"connection-per-feed", "max-connections", "connection-per-host", "host-rate", "feed-timeout",
+		"retries", "retry-backoff", "breaker-threshold", "breaker-cooldown",
+		"allow-private", "allow-hosts", "deny-hosts",
+		"max-feed-size", "max-article-size", "max-image-size", "client-config",
+	},
+	"cache": {
+		"cache-dir", "cache-ttl", "cache-size", "image-cache-dir", "image-cache-size",
+		"archive-dir", "archive-size", "archive-retention",
+	},
+	"extraction": {"rules-dir", "max-pages", "min-text-length"},
+}
+
+// restartOptions are the options of the listener, the caches, the
+// archives, the subscriptions and the scheduler, which are applied after
+// restart. The others are applied on reload.
+var restartOptions = []string{
+	"a", "p", "cache-dir", "cache-ttl", "cache-size",
+	"image-proxy", "image-key", "image-cache-dir", "image-cache-size",
+	"archive-dir", "archive-size", "archive-retention",
+	"refresh-interval", "subscription-idle", "subscriptions-file",
+	"max-connections", "connection-per-host", "host-rate",
+}
+
+// positiveOptions must be 1 or more, the other numbers must not be
+// negative.
+var positiveOptions = []string{"item-count", "max-item-count", "connection-per-feed", "max-connections", "connection-per-host", "max-pages"}
+
+// feedConfig is the defaults of a feed, which is matched by its URL. The
+// count is the number of items instead of -item-count, the workers is the
+// limit instead of -connection-per-feed, the selector and strip are used
+// if the request does not override them.
+type feedConfig struct {
+	URL      string   `json:"url"`
+	Count    int      `json:"count"`
+	Workers  int      `json:"workers"`
+	Selector []string `json:"selector"`
+	Strip    []string `json:"strip"`
+
+	rule    *siteRule
+	ruleKey string
+}
+
+// config is the options resolved from the defaults, the config file, the
+// environment variables and the command-line, in that order.
+type config struct {
+	file  string
+	flags *flag.FlagSet
+	// client is the client section, nil if there is none.
+	client *clientConfig
+	feeds  map[string]*feedConfig
+}
+
+// commandLine are the options set by the command-line, they override the
+// config file.
+var commandLine map[string]string
+
+// feedConfigs are the feed configs by URL.
+var feedConfigs atomic.Value
+
+// feedConfigFor returns the config of source, nil if there is none.
+func feedConfigFor(source string) *feedConfig {
+	m, _ := feedConfigs.Load().(map[string]*feedConfig)
+	return m[source]
+}
+
+// envName returns the environment variable of the option name.
+func envName(name string) string {
+	switch name {
+	case "a":
+		return "RSS2FULL_ADDR"
+	case "p":
+		return "RSS2FULL_PORT"
+	}
+	return "RSS2FULL_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// newConfigFlags returns a copy of the options of configSections with
+// their defaults.
+func newConfigFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	for _, options := range configSections {
+		for _, name := range options {
+			f := flag.Lookup(name)
+			switch f.Value.(flag.Getter).Get().(type) {
+			case bool:
+				fs.Bool(f.Name, false, f.Usage)
+			case int:
+				fs.Int(f.Name, 0, f.Usage)
+			case int64:
+				fs.Int64(f.Name, 0, f.Usage)
+			case float64:
+				fs.Float64(f.Name, 0, f.Usage)
+			case time.Duration:
+				fs.Duration(f.Name, 0, f.Usage)
+			default:
+				fs.String(f.Name, "", f.Usage)
+			}
+			fs.Lookup(f.Name).Value.Set(f.DefValue)
+		}
+	}
+	return fs
+}
+
+// loadConfig resolves the options with file, which may be empty.
+func loadConfig(file string) (*config, error) {
+	c := &config{file: file, flags: newConfigFlags()}
+	if file != "" {
+		if err := c.parseFile(); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if v := os.Getenv(envName(f.Name)); v != "" && err == nil {
+			if e := f.Value.Set(v); e != nil {
+				err = fmt.Errorf("%s: invalid value %q. %s", envName(f.Name), v, e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range commandLine {
+		if f := c.flags.Lookup(name); f != nil {
+			f.Value.Set(v)
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *config) parseFile() error {
+	b, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		return err
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(b, &sections); err != nil {
+		return fmt.Errorf("%s: %s", c.file, err)
+	}
+	dir := filepath.Dir(c.file)
+	for name, raw := range sections {
+		switch name {
+		case "client":
+			c.client = new(clientConfig)
+			if err := decodeStrict(raw, c.client); err != nil {
+				return fmt.Errorf("%s: client: %s", c.file, err)
+			}
+			c.client.resolve(dir)
+		case "feeds":
+			var feeds []*feedConfig
+			if err := decodeStrict(raw, &feeds); err != nil {
+				return fmt.Errorf("%s: feeds: %s", c.file, err)
+			}
+			c.feeds = make(map[string]*feedConfig, len(feeds))
+			for i, v := range feeds {
+				if err := v.compile(); err != nil {
+					return fmt.Errorf("%s: feeds[%d]: %s", c.file, i, err)
+				}
+				c.feeds[v.URL] = v
+			}
+		default:
+			options, ok := configSections[name]
+			if !ok {
+				return fmt.Errorf("%s: unknown section %q", c.file, name)
+			}
+			if err := c.parseSection(name, options, raw); err != nil {
+				return fmt.Errorf("%s: %s", c.file, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *config) parseSection(section string, options []string, raw json.RawMessage) error {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var values map[string]interface{}
+	if err := d.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %s", section, err)
+	}
+	for name, v := range values {
+		if !contains(options, name) {
+			return fmt.Errorf("%s.%s is unknown", section, name)
+		}
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("%s.%s must be a string, number or boolean", section, name)
+		}
+		if err := c.flags.Lookup(name).Value.Set(s); err != nil {
+			return fmt.Errorf("%s.%s: invalid value %q. %s", section, name, s, err)
+		}
+	}
+	return nil
+}
+
+// decodeStrict decodes raw into v, the unknown fields are errors.
+func decodeStrict(raw json.RawMessage, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
+
+func (f *feedConfig) compile() error {
+	if !isHTTPURL(f.URL) {
+		return fmt.Errorf("url must be an http or https URL(%s)", f.URL)
+	}
+	if f.Count < 0 || f.Workers < 0 {
+		return fmt.Errorf("count and workers must not be negative")
+	}
+	var err error
+	f.rule, f.ruleKey, err = overrideRule(url.Values{"selector": f.Selector, "strip": f.Strip})
+	return err
+}
+
+func (c *config) validate() error {
+	var err error
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		var negative, zero bool
+		switch v := f.Value.(flag.Getter).Get().(type) {
+		case int:
+			negative, zero = v < 0, v == 0
+		case int64:
+			negative, zero = v < 0, v == 0
+		case float64:
+			negative, zero = v < 0, v == 0
+		case time.Duration:
+			negative, zero = v < 0, v == 0
+		}
+		if negative {
+			err = fmt.Errorf("%s must not be negative(%s)", f.Name, f.Value)
+		} else if zero && contains(positiveOptions, f.Name) {
+			err = fmt.Errorf("%s must be 1 or more(%s)", f.Name, f.Value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if port := c.get("p").(int); port > 65535 {
+		return fmt.Errorf("p must be a port between 0 and 65535(%d)", port)
+	}
+	if s := c.get("base-url").(string); s != "" {
+		if u, err := url.Parse(s); err != nil || !(u.Scheme == "http" || u.Scheme == "https") {
+			return fmt.Errorf("base-url must be an http or https URL(%s)", s)
+		}
+	}
+	if c.client != nil && c.get("client-config").(string) != "" {
+		return fmt.Errorf("client-config can't be set with the client section of the config file")
+	}
+	return nil
+}
+
+func (c *config) get(name string) interface{} {
+	return c.flags.Lookup(name).Value.(flag.Getter).Get()
+}
+
+// clientConfig returns the client section, or the config of -client-config.
+func (c *config) clientConfig() (*clientConfig, error) {
+	if c.client != nil {
+		return c.client, nil
+	}
+	return loadClientConfig(c.get("client-config").(string))
+}
+
+// apply sets the options to the command-line flags, it is called before
+// they are used.
+func (c *config) apply() {
+	c.flags.VisitAll(func(f *flag.Flag) {
+		flag.Set(f.Name, f.Value.String())
+	})
+	feedConfigs.Store(c.feeds)
+}
+
+// initConfig resolves the options at startup.
+func initConfig() (*config, error) {
+	commandLine = make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = f.Value.String()
+	})
+	if *aConfig == "" {
+		*aConfig = os.Getenv("RSS2FULL_CONFIG")
+	}
+	c, err := loadConfig(*aConfig)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s", err)
+	}
+	c.apply()
+	return c, nil
+}
+
+// reloadConfig loads the config file again, and applies the reloadable
+// options. The current config is kept if the new one is invalid.
+func reloadConfig() {
+	c, err := loadConfig(*aConfig)
+	if err != nil {
+		logrus.Errorf("config: reload %s failed, keep the current config. %s", *aConfig, err)
+		return
+	}
+	var restart []string
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if contains(restartOptions, f.Name) && flag.Lookup(f.Name).Value.String() != f.Value.String() {
+			restart = append(restart, f.Name)
+		}
+	})
+	m, err := loadRules(c.get("rules-dir").(string))
+	if err != nil {
+		logrus.Errorf("config: reload %s failed, keep the current config. %s", *aConfig, err)
+		return
+	}
+	cc, err := c.clientConfig()
+	if err == nil {
+		var transport *hostTransport
+		if transport, err = newHostTransport(cc); err == nil {
+			clients.set(transport)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("config: reload %s failed, keep the current config. %s", *aConfig, err)
+		return
+	}
+	// the options read by the requests are atomic, the requests in
+	// progress may see the old or the new values.
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if !contains(restartOptions, f.Name) {
+			flag.Set(f.Name, f.Value.String())
+		}
+	})
+	setRules(m)
+	guard.update(*aAllowHosts, *aDenyHosts, *aAllowPrivate)
+	feedConfigs.Store(c.feeds)
+	if len(restart) > 0 {
+		sort.Strings(restart)
+		logrus.Warnf("config: %s changed, restart to apply", strings.Join(restart, ", "))
+	}
+	logrus.Infof("config: reloaded %s", *aConfig)
+}
+
+// watchConfig reloads the config file on SIGHUP, or when it is modified,
+// until quit is closed.
+func watchConfig(quit <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	modTime := func() time.Time {
+		fi, err := os.Stat(*aConfig)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	last := modTime()
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hup:
+			last = modTime()
+			reloadConfig()
+		case <-ticker.C:
+			if t := modTime(); !t.IsZero() && !t.Equal(last) {
+				last = t
+				reloadConfig()
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 5 * time.Second
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	commandLine = nil
+	defer func() { commandLine = nil }()
+	file := writeConfig(t, `{
+  "server": {"item-count": 20, "max-age": "1m", "failure-notice": true},
+  "fetch": {"retries": 4},
+  "feeds": [{"url": "https://example.com/rss.xml", "count": 5, "selector": ["//article"]}]
+}`)
+
+	c, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := c.get("item-count"); v != 10 {
+		t.Errorf("default: got item-count %v", v)
+	}
+
+	c, err = loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := c.get("item-count"); v != 20 {
+		t.Errorf("file: got item-count %v", v)
+	}
+	if v := c.get("failure-notice"); v != true {
+		t.Errorf("file: got failure-notice %v", v)
+	}
+	if fc := c.feeds["https://example.com/rss.xml"]; fc == nil || fc.Count != 5 || fc.rule == nil {
+		t.Errorf("file: got feed %+v", fc)
+	}
+
+	t.Setenv("RSS2FULL_ITEM_COUNT", "30")
+	t.Setenv("RSS2FULL_RETRIES", "0")
+	t.Setenv("RSS2FULL_PORT", "9000")
+	c, err = loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := c.get("item-count"); v != 30 {
+		t.Errorf("env: got item-count %v", v)
+	}
+	if v := c.get("retries"); v != 0 {
+		t.Errorf("env: got retries %v", v)
+	}
+	if v := c.get("p"); v != 9000 {
+		t.Errorf("env: got p %v", v)
+	}
+
+	commandLine = map[string]string{"item-count": "40"}
+	c, err = loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := c.get("item-count"); v != 40 {
+		t.Errorf("command-line: got item-count %v", v)
+	}
+	if v := c.get("max-age").(interface{ String() string }).String(); v != "1m0s" {
+		t.Errorf("command-line: got max-age %v", v)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	commandLine = nil
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{`{"server": {"item-count": 20}`, "unexpected end of JSON input"},
+		{`{"database": {}}`, `unknown section "database"`},
+		{`{"server": {"retries": 1}}`, "server.retries is unknown"},
+		{`{"fetch": {"retries": "many"}}`, `fetch.retries: invalid value "many"`},
+		{`{"fetch": {"retries": [1]}}`, "must be a string, number or boolean"},
+		{`{"fetch": {"feed-timeout": "-1s"}}`, "feed-timeout must not be negative"},
+		{`{"server": {"item-count": 0}}`, "item-count must be 1 or more"},
+		{`{"server": {"p": 70000}}`, "p must be a port"},
+		{`{"server": {"base-url": "ftp://example.com"}}`, "base-url must be an http or https URL"},
+		{`{"fetch": {"client-config": "client.json"}, "client": {}}`, "client-config can't be set"},
+		{`{"client": {"agent": "x"}}`, `unknown field "agent"`},
+		{`{"feeds": [{"url": "example.com"}]}`, "feeds[0]: url must be an http or https URL"},
+		{`{"feeds": [{"url": "https://example.com/", "count": -1}]}`, "must not be negative"},
+		{`{"feeds": [{"url": "https://example.com/", "selector": ["//["]}]}`, "Invalid XPath"},
+	}
+	for _, tt := range tests {
+		_, err := loadConfig(writeConfig(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.content, err, tt.err)
+		}
+	}
+
+	t.Setenv("RSS2FULL_RETRIES", "x")
+	if _, err := loadConfig(""); err == nil || !strings.Contains(err.Error(), "RSS2FULL_RETRIES") {
+		t.Errorf("env: got %v", err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	commandLine = nil
+	for _, name := range []string{"item-count", "max-age", "retries", "cache-size", "allow-private"} {
+		setFlag(t, name, flag.Lookup(name).DefValue)
+	}
+	file := writeConfig(t, `{
+  "server": {"item-count": 20, "max-age": "1m"},
+  "fetch": {"retries": 4, "allow-private": true},
+  "cache": {"cache-size": 5}
+}`)
+	setFlag(t, "config", file)
+	defer guard.update("", "", false)
+	reloadConfig()
+	if v := aItemCount.get(); v != 20 {
+		t.Errorf("got item-count %d", v)
+	}
+	if v := aMaxAge.get().String(); v != "1m0s" {
+		t.Errorf("got max-age %s", v)
+	}
+	if v := aRetries.get(); v != 4 {
+		t.Errorf("got retries %d", v)
+	}
+	if !*aAllowPrivate {
+		t.Error("allow-private is not applied")
+	}
+	// the cache is not created again, so the option is applied after restart.
+	if *aCacheSize != 1000 {
+		t.Errorf("got cache-size %d", *aCacheSize)
+	}
+
+	// the invalid config is not applied.
+	if err := ioutil.WriteFile(file, []byte(`{"server": {"item-count": 0}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig()
+	if v := aItemCount.get(); v != 20 {
+		t.Errorf("the invalid config is applied, got item-count %d", v)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/antchfx/goreadly"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/zhengchun/syndfeed"
+	"golang.org/x/net/html"
+)
+
+// article is the extracted content and metadata of an article page.
+type article struct {
+	Content    string    `json:"content"`
+	Title      string    `json:"title,omitempty"`
+	Authors    []string  `json:"authors,omitempty"`
+	Published  time.Time `json:"published,omitempty"`
+	Updated    time.Time `json:"updated,omitempty"`
+	Categories []string  `json:"categories,omitempty"`
+	// Image is the URL of the lead image.
+	Image string `json:"image,omitempty"`
+}
+
+// apply sets the content of item, and the metadata which the item
+// does not have.
+func (a *article) apply(item *syndfeed.Item, ext *itemExtension) {
+	item.Content = a.Content
+	ext.fulltext = true
+	if item.Title == "" {
+		item.Title = a.Title
+	}
+	if len(item.Authors) == 0 {
+		for _, v := range a.Authors {
+			item.Authors = append(item.Authors, &syndfeed.Person{Name: v})
+		}
+	}
+	if item.PublishDate.IsZero() {
+		item.PublishDate = a.Published
+	}
+	if item.LastUpdatedTime.IsZero() {
+		item.LastUpdatedTime = a.Updated
+	}
+	if len(item.Categories) == 0 {
+		item.Categories = a.Categories
+	}
+	if ext.image == "" {
+		ext.image = a.Image
+	}
+}
+
+// ruleFor returns the site rule of u overridden by override, or nil.
+func ruleFor(u *url.URL, override *siteRule) *siteRule {
+	rule := lookupRule(u.Hostname())
+	if override != nil {
+		rule = rule.merge(override)
+	}
+	return rule
+}
+
+// extract extracts the article of htmlDoc with the site rule, and falls
+// back to goreadly if no rule matches. The metadata of the page is
+// parsed first, the site rule overrides it.
+func extract(u *url.URL, htmlDoc *html.Node, rule *siteRule) (*article, error) {
+	a := parseMetadata(u, htmlDoc)
+	promoteImages(htmlDoc)
+	if rule != nil {
+		for _, expr := range rule.strip {
+			for _, n := range querySelectorAll(htmlDoc, expr) {
+				if n.Parent != nil {
+					n.Parent.RemoveChild(n)
+				}
+			}
+		}
+		if v := selectText(htmlDoc, rule.title); v != "" {
+			a.Title = v
+		}
+		if v := selectText(htmlDoc, rule.author); v != "" {
+			a.Authors = []string{v}
+		}
+		if t, err := parseDate(selectText(htmlDoc, rule.date)); err == nil {
+			a.Published = t
+		}
+		for _, expr := range rule.body {
+			if nodes := querySelectorAll(htmlDoc, expr); len(nodes) > 0 {
+				a.Content = normalizeContent(u, outputContent(nodes))
+				return a, nil
+			}
+		}
+	}
+	doc, err := goreadly.ParseHTML(u, htmlDoc)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Body == "" {
+		return nil, &extractError{"no content was extracted"}
+	}
+	a.Content = normalizeContent(u, doc.Body)
+	return a, nil
+}
+
+// cloneExpr returns a copy of expr for one evaluation. The compiled
+// expressions are shared by the requests, and Select of xpath copies
+// only the outer query, the inner queries keep their state.
+func cloneExpr(expr *xpath.Expr) *xpath.Expr {
+	// expr is compiled already, it does not fail.
+	return xpath.MustCompile(expr.String())
+}
+
+// querySelector returns the first node of top which matches expr, or nil.
+func querySelector(top *html.Node, expr *xpath.Expr) *html.Node {
+	return htmlquery.QuerySelector(top, cloneExpr(expr))
+}
+
+// querySelectorAll returns the nodes of top which match expr.
+func querySelectorAll(top *html.Node, expr *xpath.Expr) []*html.Node {
+	return htmlquery.QuerySelectorAll(top, cloneExpr(expr))
+}
+
+// selectText returns the text of the first node which matches any of exprs.
+func selectText(top *html.Node, exprs []*xpath.Expr) string {
+	for _, expr := range exprs {
+		if n := querySelector(top, expr); n != nil {
+			if s := strings.TrimSpace(htmlquery.InnerText(n)); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// outputContent returns the HTML of nodes, without scripts.
+func outputContent(nodes []*html.Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		removeElements(n, "script", "style")
+		html.Render(&b, n)
+	}
+	return b.String()
+}
+
+func removeElements(n *html.Node, tags ...string) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == html.ElementNode && contains(tags, child.Data) {
+			n.RemoveChild(child)
+		} else {
+			removeElements(child, tags...)
+		}
+		child = next
+	}
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+}
+
+// parseDate parses a date in any of the layouts in the wild.
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unknown date format: " + s)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/zhengchun/syndfeed"
+)
+
+// fullFeed is a source feed with the elements which syndfeed does not
+// keep, such as enclosures and extension elements with attributes.
+type fullFeed struct {
+	*syndfeed.Feed
+	// elements are the extension elements of the channel.
+	elements []*xmlquery.Node
+	items    map[*syndfeed.Item]*itemExtension
+	// ttl is the <ttl> of RSS channel, how long the feed may be cached.
+	ttl time.Duration
+	// parsed is when the source feed was parsed, the updated time of the
+	// feed which has no dates.
+	parsed time.Time
+	// archiveLinks are the links to the other pages of the history, and
+	// archive reports whether the feed is an archive document(RFC 5005).
+	archiveLinks []*syndfeed.Link
+	archive      bool
+}
+
+// itemExtension is the elements of an item which syndfeed does not keep.
+type itemExtension struct {
+	// isPermaLink is the isPermaLink attribute of <guid>, if any.
+	isPermaLink string
+	enclosures  []*enclosure
+	elements    []*xmlquery.Node
+	// image is the lead image of the article page.
+	image string
+	// fulltext reports whether the content is the extracted article.
+	fulltext bool
+	// failure is why the article failed, nil if it did not.
+	failure *itemFailure
+}
+
+// thumbnail returns the lead image of the article page, if the item has
+// no media elements of its own.
+func (e *itemExtension) thumbnail() string {
+	for _, v := range e.elements {
+		if v.NamespaceURI == "http://search.yahoo.com/mrss/" {
+			return ""
+		}
+	}
+	return e.image
+}
+
+type enclosure struct {
+	URL, Length, Type string
+}
+
+// ext returns the extension of item, never nil. The extension of an item
+// which has none is added, so it can be modified.
+func (f *fullFeed) ext(item *syndfeed.Item) *itemExtension {
+	if v, ok := f.items[item]; ok {
+		return v
+	}
+	v := new(itemExtension)
+	if f.items == nil {
+		f.items = make(map[*syndfeed.Item]*itemExtension)
+	}
+	f.items[item] = v
+	return v
+}
+
+// enclosures returns the enclosures of item, the links with
+// rel="enclosure" of Atom feeds included.
+func (f *fullFeed) enclosures(item *syndfeed.Item) []*enclosure {
+	list := f.ext(item).enclosures
+	for _, v := range item.Links {
+		if v.RelType == "enclosure" {
+			list = append(list, &enclosure{URL: v.URL, Type: v.MediaType})
+		}
+	}
+	return list
+}
+
+// parseFeed parses a syndication feed, and then the document again for
+// the elements which syndfeed does not keep.
+func parseFeed(b []byte) (*fullFeed, error) {
+	doc, err := xmlquery.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	// the items of RSS 1.0 are not in its channel, syndfeed does not
+	// parse it.
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == xmlquery.ElementNode && n.Data == "RDF" {
+			return nil, errors.New("RSS 1.0 feed(rdf:RDF) is not supported")
+		}
+	}
+	feed, err := syndfeed.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	f := &fullFeed{Feed: feed, items: make(map[*syndfeed.Item]*itemExtension), parsed: time.Now()}
+	var channel *xmlquery.Node
+	if root := doc.SelectElement("rss"); root != nil {
+		channel = root.SelectElement("channel")
+	} else {
+		channel = doc.SelectElement("feed")
+	}
+	if channel == nil {
+		return f, nil
+	}
+	var i int
+	for elem := channel.FirstChild; elem != nil; elem = elem.NextSibling {
+		if elem.Type != xmlquery.ElementNode {
+			continue
+		}
+		if elem.Prefix == "" && (elem.Data == "item" || elem.Data == "entry") {
+			if i < len(feed.Items) {
+				f.items[feed.Items[i]] = parseItemExtension(elem, feed.Namespace)
+			}
+			i++
+			continue
+		}
+		if elem.Prefix == "" && elem.Data == "ttl" {
+			if n, err := strconv.Atoi(strings.TrimSpace(elem.InnerText())); err == nil && n > 0 {
+				f.ttl = time.Duration(n) * time.Minute
+			}
+			continue
+		}
+		if isExtensionElement(elem, feed.Namespace) && !isSourceLink(elem) {
+			f.elements = append(f.elements, elem)
+		}
+	}
+	// the feed is shared by the requests, every item has its extension
+	// so ext never adds one.
+	for _, item := range feed.Items {
+		f.ext(item)
+	}
+	return f, nil
+}
+
+func parseItemExtension(self *xmlquery.Node, ns map[string]string) *itemExtension {
+	ext := new(itemExtension)
+	for elem := self.FirstChild; elem != nil; elem = elem.NextSibling {
+		if elem.Type != xmlquery.ElementNode {
+			continue
+		}
+		switch {
+		case elem.Prefix == "" && elem.Data == "guid":
+			ext.isPermaLink = elem.SelectAttr("isPermaLink")
+		case elem.Prefix == "" && elem.Data == "enclosure":
+			ext.enclosures = append(ext.enclosures, &enclosure{
+				URL:    elem.SelectAttr("url"),
+				Length: elem.SelectAttr("length"),
+				Type:   elem.SelectAttr("type"),
+			})
+		case isExtensionElement(elem, ns):
+			ext.elements = append(ext.elements, elem)
+		}
+	}
+	return ext
+}
+
+// isExtensionElement reports whether elem is an extension element which
+// the writers output as it is. The elements already mapped to the fields
+// of syndfeed.Item are not.
+func isExtensionElement(elem *xmlquery.Node, ns map[string]string) bool {
+	if _, ok := ns[elem.Prefix]; !ok || elem.Prefix == "" {
+		return false
+	}
+	switch ns[elem.Prefix] {
+	case "http://purl.org/rss/1.0/modules/content/":
+		return elem.Data != "encoded"
+	case "http://purl.org/dc/elements/1.1/":
+		switch elem.Data {
+		case "title", "creator", "description", "contributor", "date", "language", "rights":
+			return false
+		}
+	}
+	return true
+}
+
+// sourceLinkRels are the relations of the links to the source feed itself,
+// its hub and its pages.
+var sourceLinkRels = []string{
+	"self", "hub", "first", "last", "next", "previous", "current", "prev-archive", "next-archive",
+}
+
+// isSourceLink reports whether elem is an <atom:link> to the source feed
+// itself, which would be wrong in the full-text feed.
+func isSourceLink(elem *xmlquery.Node) bool {
+	if elem.NamespaceURI != "http://www.w3.org/2005/Atom" || elem.Data != "link" {
+		return false
+	}
+	return contains(sourceLinkRels, elem.SelectAttr("rel"))
+}
+
+// clone returns a copy of f whose items and their extensions can be
+// modified.
+func (f *fullFeed) clone() *fullFeed {
+	feed := *f.Feed
+	v := &fullFeed{
+		Feed:     &feed,
+		elements: f.elements,
+		items:    make(map[*syndfeed.Item]*itemExtension, len(f.items)),
+		ttl:      f.ttl,
+		parsed:   f.parsed,
+	}
+	feed.Items = make([]*syndfeed.Item, len(f.Items))
+	for i, item := range f.Items {
+		newItem := *item
+		feed.Items[i] = &newItem
+		// f may be shared, so its items are not added by ext.
+		var ext itemExtension
+		if e, ok := f.items[item]; ok {
+			ext = *e
+		}
+		v.items[&newItem] = &ext
+	}
+	return v
+}
+
+// namespaces returns the namespaces of the source feed, except the
+// prefixes which the writer declares itself.
+func (f *fullFeed) namespaces(declared ...string) []string {
+	var prefixes []string
+	for prefix := range f.Namespace {
+		if prefix != "" && !contains(declared, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	// keep the output stable for the ETag.
+	sort.Strings(prefixes)
+	var attrs []string
+	for _, prefix := range prefixes {
+		attrs = append(attrs, "xmlns:"+prefix, f.Namespace[prefix])
+	}
+	return attrs
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
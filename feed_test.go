@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/zhengchun/syndfeed"
+)
+
+func TestFullFeedExt(t *testing.T) {
+	f := new(fullFeed)
+	item := new(syndfeed.Item)
+	f.ext(item).image = "http://example.com/a.png"
+	if got := f.ext(item).image; got != "http://example.com/a.png" {
+		t.Errorf("got image %q", got)
+	}
+
+	feed, err := parseFeed([]byte(`<rss version="2.0"><channel><title>t</title><item><title>a</title></item></channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.items) != len(feed.Items) {
+		t.Errorf("got %d extensions of %d items", len(feed.items), len(feed.Items))
+	}
+	// the clone has its own extensions.
+	v := feed.clone()
+	v.ext(v.Items[0]).image = "http://example.com/b.png"
+	if feed.ext(feed.Items[0]).image != "" {
+		t.Error("the extension of the source feed is modified")
+	}
+}
+
+func TestParseFeedRDF(t *testing.T) {
+	b := []byte(`<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/">
+<channel rdf:about="http://example.com/"><title>t</title></channel>
+<item rdf:about="http://example.com/a"><title>a</title><link>http://example.com/a</link></item>
+</rdf:RDF>`)
+	if _, err := parseFeed(b); err == nil {
+		t.Error("RSS 1.0 feed is parsed")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The options which are read by the requests are atomic, so they can be
+// set when the config is reloaded.
+
+type intFlag struct{ v int64 }
+
+func newIntFlag(name string, value int, usage string) *intFlag {
+	f := &intFlag{v: int64(value)}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *intFlag) get() int         { return int(atomic.LoadInt64(&f.v)) }
+func (f *intFlag) Get() interface{} { return f.get() }
+func (f *intFlag) String() string   { return strconv.Itoa(f.get()) }
+func (f *intFlag) Set(s string) error {
+	v, err := strconv.ParseInt(s, 0, strconv.IntSize)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&f.v, v)
+	return nil
+}
+
+type int64Flag struct{ v int64 }
+
+func newInt64Flag(name string, value int64, usage string) *int64Flag {
+	f := &int64Flag{v: value}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *int64Flag) get() int64       { return atomic.LoadInt64(&f.v) }
+func (f *int64Flag) Get() interface{} { return f.get() }
+func (f *int64Flag) String() string   { return strconv.FormatInt(f.get(), 10) }
+func (f *int64Flag) Set(s string) error {
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&f.v, v)
+	return nil
+}
+
+type boolFlag struct{ v int32 }
+
+func newBoolFlag(name string, value bool, usage string) *boolFlag {
+	f := new(boolFlag)
+	if value {
+		f.v = 1
+	}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *boolFlag) get() bool        { return atomic.LoadInt32(&f.v) != 0 }
+func (f *boolFlag) Get() interface{} { return f.get() }
+func (f *boolFlag) String() string   { return strconv.FormatBool(f.get()) }
+func (f *boolFlag) IsBoolFlag() bool { return true }
+func (f *boolFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	var n int32
+	if v {
+		n = 1
+	}
+	atomic.StoreInt32(&f.v, n)
+	return nil
+}
+
+type durationFlag struct{ v int64 }
+
+func newDurationFlag(name string, value time.Duration, usage string) *durationFlag {
+	f := &durationFlag{v: int64(value)}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *durationFlag) get() time.Duration { return time.Duration(atomic.LoadInt64(&f.v)) }
+func (f *durationFlag) Get() interface{}   { return f.get() }
+func (f *durationFlag) String() string     { return f.get().String() }
+func (f *durationFlag) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&f.v, int64(v))
+	return nil
+}
+
+type stringFlag struct{ v atomic.Value }
+
+func newStringFlag(name string, value string, usage string) *stringFlag {
+	f := new(stringFlag)
+	f.v.Store(value)
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *stringFlag) get() string        { return f.v.Load().(string) }
+func (f *stringFlag) Get() interface{}   { return f.get() }
+func (f *stringFlag) String() string     { return f.get() }
+func (f *stringFlag) Set(s string) error { f.v.Store(s); return nil }
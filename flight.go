@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// sourceFlight coalesces the fetches of a source feed.
+	sourceFlight = newFlightGroup("source")
+	// feedFlight coalesces the builds of a full-text feed.
+	feedFlight = newFlightGroup("feed")
+	// articleFlight coalesces the extractions of an article.
+	articleFlight = newFlightGroup("article")
+
+	flightGroups = []*flightGroup{sourceFlight, feedFlight, articleFlight}
+)
+
+// flightGroup coalesces the concurrent calls of the same key, the callers
+// wait for the first call and share its result.
+type flightGroup struct {
+	// total is the number of calls, coalesced is the number of them which
+	// shared the result of another call. They are first for the 64-bit
+	// alignment of atomic.
+	total     int64
+	coalesced int64
+
+	name  string
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+	// refs is the number of the callers waiting for the call, the call
+	// is cancelled if all of them are gone.
+	refs   int
+	cancel context.CancelFunc
+}
+
+func newFlightGroup(name string) *flightGroup {
+	return &flightGroup{name: name, calls: make(map[string]*flightCall)}
+}
+
+// Do calls fn once for the concurrent calls of key, and returns its result
+// to all of them. fn gets a context of its own, which is cancelled only if
+// the contexts of all callers are done, so a caller which is gone does not
+// cancel the call of the others.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	atomic.AddInt64(&g.total, 1)
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if ok {
+		atomic.AddInt64(&g.coalesced, 1)
+		c.refs++
+	} else {
+		var callCtx context.Context
+		c = &flightCall{done: make(chan struct{}), refs: 1}
+		callCtx, c.cancel = context.WithCancel(context.Background())
+		g.calls[key] = c
+		go g.call(callCtx, c, key, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.refs--
+		if c.refs == 0 {
+			c.cancel()
+			// the next caller starts a new call.
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) call(ctx context.Context, c *flightCall, key string, fn func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		// the waiters get the error if fn panics.
+		if v := recover(); v != nil {
+			c.val, c.err = nil, fmt.Errorf("call %s panicked: %v", key, v)
+			logrus.Errorf("%s: %s\n%s", g.name, c.err, debug.Stack())
+		}
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+	c.val, c.err = fn(ctx)
+}
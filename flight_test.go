@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitRefs waits until n callers wait for the call of key.
+func waitRefs(g *flightGroup, key string, n int) {
+	for {
+		g.mu.Lock()
+		c := g.calls[key]
+		ok := c != nil && c.refs == n
+		g.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlightGroupDo(t *testing.T) {
+	g := newFlightGroup("test")
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "v", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan interface{}, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Do(context.Background(), "key", fn)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- v
+		}()
+	}
+	waitRefs(g, "key", 5)
+	close(release)
+	wg.Wait()
+	close(results)
+	for v := range results {
+		if v != "v" {
+			t.Errorf("got %v", v)
+		}
+	}
+	if calls != 1 || g.coalesced != 4 {
+		t.Errorf("got %d calls and %d coalesced, want 1 and 4", calls, g.coalesced)
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("%d calls are kept", len(g.calls))
+	}
+
+	// the next call is not coalesced with the finished one.
+	v, err := g.Do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	if v != nil || err == nil || err.Error() != "failed" {
+		t.Errorf("got %v, %v", v, err)
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	g := newFlightGroup("test")
+	_, err := g.Do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		panic("boom")
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("got %v", err)
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("%d calls are kept", len(g.calls))
+	}
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+	g := newFlightGroup("test")
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err := g.Do(ctx1, "key", fn)
+		errs <- err
+	}()
+	go func() {
+		_, err := g.Do(ctx2, "key", fn)
+		errs <- err
+	}()
+	waitRefs(g, "key", 2)
+
+	// a caller which is gone does not cancel the call of the other.
+	cancel1()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("the call is cancelled by one of the callers")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the call is cancelled when all of the callers are gone.
+	cancel2()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call is not cancelled")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.calls) != 0 {
+		t.Errorf("%d calls are kept", len(g.calls))
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// guard decides which hosts and addresses may be fetched, so rss2full
+// can't be used to reach the internal network(SSRF).
+var guard = newHostGuard("", "", false)
+
+// hostGuard checks the host names of URLs with the allow and deny lists,
+// and the IP addresses when dialing, so a host name which resolves to an
+// internal address is rejected too. The policy is replaced when the config
+// is reloaded.
+type hostGuard struct {
+	policy atomic.Value
+}
+
+type guardPolicy struct {
+	allow        *hostList
+	deny         *hostList
+	allowPrivate bool
+}
+
+// hostList is a list of host names and networks. A name matches the host
+// and its subdomains.
+type hostList struct {
+	names []string
+	nets  []*net.IPNet
+}
+
+// internalNets are the networks which are not public, in addition to
+// the loopback, private, link-local and multicast ones.
+var internalNets = parseNets(
+	"0.0.0.0/8",     // this network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+	"64:ff9b::/96",  // NAT64, may embed an internal IPv4 address
+)
+
+func parseNets(list ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range list {
+		_, n, _ := net.ParseCIDR(s)
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// parseHostList parses a comma-separated list of host names, IP
+// addresses and CIDRs.
+func parseHostList(s string) *hostList {
+	l := new(hostList)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(v); err == nil {
+			l.nets = append(l.nets, n)
+		} else if ip := net.ParseIP(v); ip != nil {
+			l.nets = append(l.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		} else {
+			l.names = append(l.names, strings.TrimPrefix(v, "."))
+		}
+	}
+	return l
+}
+
+func (l *hostList) empty() bool {
+	return len(l.names) == 0 && len(l.nets) == 0
+}
+
+func (l *hostList) matchName(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, v := range l.names {
+		if host == v || strings.HasSuffix(host, "."+v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *hostList) matchIP(ip net.IP) bool {
+	for _, n := range l.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forbiddenError is the error of a host or an address which may not be
+// fetched, it is never retried.
+type forbiddenError struct {
+	msg string
+}
+
+func (e *forbiddenError) Error() string {
+	return e.msg
+}
+
+func forbiddenf(format string, a ...interface{}) error {
+	return &forbiddenError{fmt.Sprintf(format, a...)}
+}
+
+func newHostGuard(allow, deny string, allowPrivate bool) *hostGuard {
+	g := new(hostGuard)
+	g.update(allow, deny, allowPrivate)
+	return g
+}
+
+func (g *hostGuard) update(allow, deny string, allowPrivate bool) {
+	g.policy.Store(&guardPolicy{
+		allow:        parseHostList(allow),
+		deny:         parseHostList(deny),
+		allowPrivate: allowPrivate,
+	})
+}
+
+// checkURL returns an error if u may not be fetched.
+func (g *hostGuard) checkURL(u *url.URL) error {
+	return g.policy.Load().(*guardPolicy).checkURL(u)
+}
+
+// checkIP returns an error if ip may not be connected.
+func (g *hostGuard) checkIP(ip net.IP) error {
+	return g.policy.Load().(*guardPolicy).checkIP(ip)
+}
+
+func (g *guardPolicy) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return forbiddenf("%s is not http or https", u)
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if len(g.allow.names) > 0 && !g.allow.matchIP(ip) {
+			return forbiddenf("%s is not allowed", ip)
+		}
+		return g.checkIP(ip)
+	}
+	if g.deny.matchName(host) {
+		return forbiddenf("%s is denied", host)
+	}
+	if len(g.allow.names) > 0 && !g.allow.matchName(host) {
+		return forbiddenf("%s is not allowed", host)
+	}
+	return nil
+}
+
+func (g *guardPolicy) checkIP(ip net.IP) error {
+	if g.deny.matchIP(ip) {
+		return forbiddenf("%s is denied", ip)
+	}
+	if g.allow.matchIP(ip) {
+		return nil
+	}
+	if !g.allow.empty() && len(g.allow.names) == 0 {
+		return forbiddenf("%s is not allowed", ip)
+	}
+	if !g.allowPrivate && isInternalIP(ip) {
+		return forbiddenf("%s is an internal address", ip)
+	}
+	return nil
+}
+
+func isInternalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range internalNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// control checks the address to connect, which is resolved already, so a
+// DNS rebinding can't get around checkURL.
+func (g *hostGuard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%s is not an IP address", host)
+	}
+	return g.checkIP(ip)
+}
+
+// checkRedirect checks every redirect of the requests.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return guard.checkURL(req.URL)
+}
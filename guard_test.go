@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsInternalIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"100.64.0.1", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"240.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fc00::1", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"64:ff9b::a00:1", true},
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+	}
+	for _, tt := range tests {
+		if got := isInternalIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isInternalIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestGuardCheckIP(t *testing.T) {
+	tests := []struct {
+		allow, deny  string
+		allowPrivate bool
+		ip           string
+		ok           bool
+	}{
+		{"", "", false, "93.184.216.34", true},
+		{"", "", false, "10.0.0.1", false},
+		{"", "", true, "10.0.0.1", true},
+		{"", "93.184.216.0/24", false, "93.184.216.34", false},
+		{"", "10.0.0.1", true, "10.0.0.1", false},
+		// the allowed networks may be internal.
+		{"10.0.0.0/8", "", false, "10.0.0.1", true},
+		{"10.0.0.0/8", "10.0.0.1", false, "10.0.0.1", false},
+		// only the allowed networks, if no host name is allowed.
+		{"10.0.0.0/8", "", false, "93.184.216.34", false},
+		{"example.com", "", false, "93.184.216.34", true},
+		{"example.com", "", false, "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		g := newHostGuard(tt.allow, tt.deny, tt.allowPrivate)
+		err := g.checkIP(net.ParseIP(tt.ip))
+		if (err == nil) != tt.ok {
+			t.Errorf("checkIP(%s) with allow %q, deny %q = %v", tt.ip, tt.allow, tt.deny, err)
+		}
+		if _, ok := err.(*forbiddenError); err != nil && !ok {
+			t.Errorf("checkIP(%s) = %T, want *forbiddenError", tt.ip, err)
+		}
+	}
+}
+
+func TestGuardCheckURL(t *testing.T) {
+	tests := []struct {
+		allow, deny string
+		url         string
+		ok          bool
+	}{
+		{"", "", "http://example.com/feed", true},
+		{"", "", "https://example.com/feed", true},
+		{"", "", "ftp://example.com/feed", false},
+		{"", "", "file:///etc/passwd", false},
+		{"", "", "http://127.0.0.1:8080/", false},
+		{"", "", "http://[::1]/", false},
+		// the host names are checked when dialing.
+		{"", "", "http://localhost/", true},
+		{"", "example.com", "http://example.com/", false},
+		{"", ".example.com", "http://www.EXAMPLE.com./", false},
+		{"", "example.com", "http://notexample.com/", true},
+		{"example.com", "", "http://blog.example.com/", true},
+		{"example.com", "", "http://example.org/", false},
+		{"example.com", "", "http://93.184.216.34/", false},
+		{"example.com, 93.184.216.0/24", "", "http://93.184.216.34/", true},
+		{"example.com", "blog.example.com", "http://blog.example.com/", false},
+	}
+	for _, tt := range tests {
+		g := newHostGuard(tt.allow, tt.deny, false)
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := g.checkURL(u); (err == nil) != tt.ok {
+			t.Errorf("checkURL(%s) with allow %q, deny %q = %v", tt.url, tt.allow, tt.deny, err)
+		}
+	}
+}
+
+func TestGuardControl(t *testing.T) {
+	g := newHostGuard("", "", false)
+	if err := g.control("tcp", "127.0.0.1:80", nil); err == nil {
+		t.Error("connected to the loopback address")
+	}
+	if err := g.control("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Error(err)
+	}
+	if err := g.control("tcp", "example.com:80", nil); err == nil {
+		t.Error("connected to a host name")
+	}
+}
+
+func TestGuardEnvironmentProxy(t *testing.T) {
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		w.Write([]byte("proxy"))
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("HTTPS_PROXY", proxy.URL)
+	t.Setenv("NO_PROXY", "")
+
+	if newTransport().Proxy != nil {
+		t.Fatal("the transport uses the proxy of the environment")
+	}
+	// the request goes to the host, whose address is checked, not
+	// through the proxy, whose address would be checked instead.
+	ht := useTestClient(t)
+	defer ht.closeIdleConnections()
+	for _, u := range []string{"http://rss2full.invalid/", "https://rss2full.invalid/"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		if resp, err := ht.RoundTrip(req); err == nil {
+			resp.Body.Close()
+			t.Errorf("GET %s got %s", u, resp.Status)
+		}
+	}
+	if proxied != 0 {
+		t.Errorf("got %d proxied requests", proxied)
+	}
+}
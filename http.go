@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/antchfx/goreadly"
 	"github.com/antchfx/htmlquery"
 	"github.com/sirupsen/logrus"
 
@@ -20,21 +25,44 @@ import (
 )
 
 var httpClient = &http.Client{
-	Timeout: time.Second * 45,
+	Timeout:       time.Second * 45,
+	Transport:     clients,
+	CheckRedirect: checkRedirect,
 }
 
-func httpGet(url string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// newTransport returns the transport whose connections are checked by
+// guard. The proxy of the environment is not used, guard would check the
+// address of the proxy only, the proxies are set by -client-config.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return guard.control(network, address, c)
+		},
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/63.0.3239.132 Safari/537.36")
-	// bug has fixed： https://github.com/golang/go/issues/18779
-	resp, err := httpClient.Do(req)
+	t.DialContext = dialer.DialContext
+	return t
+}
+
+// httpGet gets url, the body of response is converted to UTF-8.
+func httpGet(ctx context.Context, url string, header http.Header, limit int64) (*http.Response, error) {
+	resp, err := httpDo(ctx, url, header, limit)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		// the body of the other status is not read, it may have none,
+		// such as 304 or a bodiless 404.
+		return resp, nil
+	}
 	r, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err == io.EOF {
+		// the body is empty.
+		r, err = strings.NewReader(""), nil
+	}
 	if err != nil {
 		resp.Body.Close()
 		return nil, err
@@ -43,6 +71,77 @@ func httpGet(url string) (*http.Response, error) {
 	return resp, nil
 }
 
+// httpDo gets url as it is, such as an image. The body larger than limit
+// bytes is rejected, 0 for unlimited.
+func httpDo(ctx context.Context, rawurl string, header http.Header, limit int64) (*http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if err := guard.checkURL(u); err != nil {
+		return nil, err
+	}
+	resp, err := doWithRetry(ctx, u.Host, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", rawurl, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		// bug has fixed： https://github.com/golang/go/issues/18779
+		return req, nil
+	})
+	if err != nil || limit <= 0 {
+		return resp, err
+	}
+	// reject it early if the size is known.
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s got body is too large(%d bytes)", rawurl, resp.ContentLength)
+	}
+	resp.Body = &limitedReader{rc: resp.Body, n: limit, limit: limit}
+	return resp, nil
+}
+
+// limitedReader reads up to limit bytes, and then fails, so a large body
+// is not truncated silently.
+type limitedReader struct {
+	rc    io.ReadCloser
+	n     int64
+	limit int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.n+1 {
+		p = p[:r.n+1]
+	}
+	n, err := r.rc.Read(p)
+	if int64(n) > r.n {
+		n, r.n = int(r.n), 0
+		return n, fmt.Errorf("body is larger than %d bytes", r.limit)
+	}
+	r.n -= int64(n)
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	return r.rc.Close()
+}
+
+// htmlMediaTypes are the media types of article pages.
+var htmlMediaTypes = []string{"", "text/html", "application/xhtml+xml"}
+
+// checkHTML returns an error if resp is not an HTML page, such as a PDF
+// or a video linked from a feed.
+func checkHTML(resp *http.Response) error {
+	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !contains(htmlMediaTypes, mediatype) {
+		return &extractError{fmt.Sprintf("%s got mediatype is not html(%s)", resp.Request.URL, mediatype)}
+	}
+	return nil
+}
+
 type responseReader struct {
 	rc io.ReadCloser
 	r  io.Reader
@@ -56,102 +155,324 @@ func (r *responseReader) Close() error {
 	return r.rc.Close()
 }
 
+// feedWriter writes a feed in one of the output formats.
+type feedWriter struct {
+	contentType string
+	output      func(io.Writer, *fullFeed) error
+}
+
+var (
+	rss20Writer = feedWriter{"application/xml", outputRss20}
+	atomWriter  = feedWriter{"application/atom+xml", outputAtom}
+	jsonWriter  = feedWriter{"application/feed+json", outputJSONFeed}
+)
+
+// FullRss outputs the full-text feed as RSS 2.0, or JSON Feed if the
+// client accepts application/feed+json.
 func FullRss(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	// skip a /feed/ segment.
-	source := r.URL.String()[6:]
-	// decode
-	source, _ = url.QueryUnescape(source)
-	if source == "" || !(strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")) {
-		w.WriteHeader(400)
-		w.Write([]byte(fmt.Sprintf("Invalid source feed(%s)", source)))
+	w.Header().Set("Vary", "Accept")
+	if acceptsJSONFeed(r) {
+		serveFeed(w, r, jsonWriter)
 		return
 	}
-	handleError := func(err error) {
-		w.WriteHeader(500)
-		w.Write([]byte(err.Error()))
+	serveFeed(w, r, rss20Writer)
+}
+
+// FullAtom outputs the full-text feed as Atom 1.0.
+func FullAtom(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	serveFeed(w, r, atomWriter)
+}
+
+// FullJSON outputs the full-text feed as JSON Feed 1.1.
+func FullJSON(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	serveFeed(w, r, jsonWriter)
+}
+
+// ready is 1 if rss2full accepts the requests, it is 0 while starting and
+// shutting down.
+var ready int32
+
+// Ready responds 200 if rss2full is ready, or 503, for the readiness
+// check of a load balancer.
+func Ready(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if atomic.LoadInt32(&ready) == 0 {
+		w.WriteHeader(503)
+		w.Write([]byte("not ready"))
+		return
+	}
+	w.Write([]byte("ready"))
+}
+
+// acceptsJSONFeed reports whether the Accept header of r lists
+// application/feed+json with a q value over 0.
+func acceptsJSONFeed(r *http.Request) bool {
+	for _, v := range strings.Split(strings.Join(r.Header.Values("Accept"), ","), ",") {
+		mediatype, params, err := mime.ParseMediaType(v)
+		if err != nil || mediatype != "application/feed+json" {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		return q > 0
 	}
-	resp, err := httpGet(source)
+	return false
+}
+
+func serveFeed(w http.ResponseWriter, r *http.Request, fw feedWriter) {
+	opts, err := parseFeedOptions(r, fw)
 	if err != nil {
-		handleError(err)
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		handleError(fmt.Errorf("%s got status-code is not 200(%d)", source, resp.StatusCode))
+	ctx := r.Context()
+	var feed *fullFeed
+	if *aRefreshInterval > 0 {
+		feed, err = subscriptions.Get(ctx, opts)
+	} else {
+		feed, err = buildFeed(ctx, opts)
+	}
+	if err == nil && archives != nil {
+		err = archives.View(feed, opts, historyLink(r, opts))
+	}
+	if err != nil && ctx.Err() != nil {
+		logrus.Debugf("%s: the client is gone. %s", opts.source, err)
 		return
 	}
-	v := resp.Header.Get("Content-Type")
-	mediatype, _, err := mime.ParseMediaType(v)
-	var feed *syndfeed.Feed
-	switch mediatype {
-	case "text/xml",
-		"application/xml",
-		"application/rss+xml",
-		"application/atom+xml":
-		var err error
-		feed, err = syndfeed.Parse(resp.Body)
-		if err != nil {
-			handleError(err)
-			return
+	if err != nil {
+		status := 500
+		if err == errPageNotFound {
+			status = 404
 		}
-	default:
-		handleError(fmt.Errorf("%s got mediatype is not supported(%s)", source, mediatype))
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
 		return
 	}
-	if len(feed.Items) > 0 {
-		if len(feed.Items) > *aItemCount {
-			feed.Items = feed.Items[:*aItemCount]
+	if aFailureNotice.get() {
+		addFailureNotices(feed)
+	}
+	if *aImageProxy {
+		proxyImages(feed, baseURL(r))
+	}
+	writeFeed(w, r, feed, opts.writer)
+}
+
+// buildFeed loads the source feed of opts and extracts the full text of
+// its items. The concurrent builds of the same feed are coalesced, a build
+// is cancelled if all of its callers are gone.
+func buildFeed(ctx context.Context, opts *feedOptions) (*fullFeed, error) {
+	key := subscriptionKey(opts.source, opts.count, opts.ruleKey)
+	v, err := feedFlight.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return buildFullFeed(ctx, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the callers modify the feed, so every caller gets its own copy.
+	return v.(*fullFeed).clone(), nil
+}
+
+// buildFullFeed builds the feed in -feed-timeout, the articles which are
+// not ready then are cancelled, and their items keep the summary.
+func buildFullFeed(ctx context.Context, opts *feedOptions) (*fullFeed, error) {
+	parent := ctx
+	if aFeedTimeout.get() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, aFeedTimeout.get())
+		defer cancel()
+	}
+	feed, err := loadFeed(ctx, opts.source)
+	if err != nil {
+		return nil, err
+	}
+	if len(feed.Items) > opts.count {
+		feed.Items = feed.Items[:opts.count]
+	}
+	type result struct {
+		item *syndfeed.Item
+		a    *article
+		err  error
+	}
+	// the results of the fetches after the deadline are dropped, so the
+	// fetches never modify the feed.
+	results := make(chan result, len(feed.Items))
+	q := scheduler.newQueue(opts.workers)
+	defer q.close()
+	// pending are the items whose articles are not ready.
+	pending := make(map[*syndfeed.Item]bool)
+	for _, item := range feed.Items {
+		if len(item.Links) == 0 {
+			continue
+		}
+		item, link := item, item.Links[0].URL
+		var host string
+		if u, err := url.Parse(link); err == nil {
+			host = u.Host
 		}
-		var wg sync.WaitGroup
-		c := make(chan struct{})
-		// create 2 worker to work.
-		var queue = make(chan *syndfeed.Item, 1)
-		for n := 0; n < *aConnectionPerFeed; n++ {
-			go func() {
-				for {
-					select {
-					case item := <-queue:
-						link := item.Links[0].URL
-						logrus.Debugf("%s", link)
-						if err := fulltext(item, link); err != nil {
-							wg.Done()
-							logrus.Warnf("GET %s failed. %s", link, err)
-						}
-						wg.Done()
-					case <-c:
-						return
-					}
+		id := item.Id
+		pending[item] = true
+		q.add(host, func() {
+			// the feed is done while the fetch is queued.
+			if err := ctx.Err(); err != nil {
+				results <- result{item, nil, err}
+				return
+			}
+			logrus.Debugf("%s", link)
+			a, err := fulltext(ctx, link, id, opts)
+			if err != nil {
+				logrus.Warnf("GET %s failed. %s", link, err)
+			}
+			results <- result{item, a, err}
+		})
+	}
+	n := len(pending)
+wait:
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.item)
+			if r.err != nil {
+				feed.ext(r.item).failure = newFailure(r.err)
+				continue
+			}
+			r.a.apply(r.item, feed.ext(r.item))
+		case <-ctx.Done():
+			if err := parent.Err(); err != nil {
+				return nil, err
+			}
+			logrus.Warnf("%s: %d of %d articles are not ready in %s, serve the partial feed", opts.source, len(pending), n, aFeedTimeout.get())
+			for item := range pending {
+				feed.ext(item).failure = &itemFailure{
+					reason:  failureTimeout,
+					message: fmt.Sprintf("not ready in %s", aFeedTimeout.get()),
 				}
-			}()
+			}
+			break wait
 		}
-		for _, item := range feed.Items {
-			if len(item.Links) > 0 {
-				wg.Add(1)
-				queue <- item
+	}
+	if archives != nil {
+		archives.Add(opts.source, feed)
+	}
+	return feed, nil
+}
+
+// writeFeed streams the generated feed with ETag and Last-Modified, and
+// responds 304 if the client already has the same feed. The ETag is the
+// hash of the document, so the feed is rendered into the hash first.
+func writeFeed(w http.ResponseWriter, r *http.Request, feed *fullFeed, fw feedWriter) {
+	h := sha1.New()
+	if err := fw.output(h, feed); err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+	modtime := lastModified(feed.Feed)
+	w.Header().Set("Content-Type", fw.contentType)
+	w.Header().Set("ETag", etag)
+	if !modtime.IsZero() {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(aMaxAge.get().Seconds())))
+	if v := feed.failures(); v != "" {
+		w.Header().Set("X-Rss2full-Failures", v)
+	}
+	if notModified(r, etag, modtime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+	if err := fw.output(w, feed); err != nil {
+		logrus.Warnf("write feed failed. %s", err)
+	}
+}
+
+// notModified reports whether the client's copy matches etag or is not
+// older than modtime. If-None-Match takes precedence over
+// If-Modified-Since as RFC 7232 section 6.
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+			if v == "*" || v == etag {
+				return true
 			}
 		}
-		wg.Wait()
-		close(c)
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modtime.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modtime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// lastModified returns the time of the newest item in the feed.
+func lastModified(feed *syndfeed.Feed) time.Time {
+	t := feed.LastUpdatedTime
+	for _, item := range feed.Items {
+		if item.PublishDate.After(t) {
+			t = item.PublishDate
+		}
+		if item.LastUpdatedTime.After(t) {
+			t = item.LastUpdatedTime
+		}
+	}
+	if now := time.Now(); t.After(now) {
+		t = now
 	}
-	// outout RSS 2.0
-	w.Header().Set("Content-Type", "application/xml")
-	outputRss20(w.(io.StringWriter), feed)
+	return t
 }
 
-func fulltext(item *syndfeed.Item, link string) error {
-	resp, err := httpGet(link)
+// fulltext returns the article of link, from the article cache if it is
+// extracted already. The concurrent extractions of the same article are
+// coalesced, the article must not be modified.
+func fulltext(ctx context.Context, link, id string, opts *feedOptions) (*article, error) {
+	key := articleKey(link, id)
+	if opts.ruleKey != "" {
+		key += "?" + opts.ruleKey
+	}
+	if a, ok := articles.Get(key); ok {
+		return a, nil
+	}
+	v, err := articleFlight.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return extractArticle(ctx, link, key, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*article), nil
+}
+
+func extractArticle(ctx context.Context, link, key string, opts *feedOptions) (*article, error) {
+	resp, err := httpGet(ctx, link, nil, aMaxArticleSize.get()<<20)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &statusError{link, resp.StatusCode}
+	}
+	if err := checkHTML(resp); err != nil {
+		return nil, err
+	}
 	htmlDoc, err := htmlquery.Parse(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	doc, err := goreadly.ParseHTML(resp.Request.URL, htmlDoc)
+	a, err := extractPages(ctx, resp.Request.URL, htmlDoc, ruleFor(resp.Request.URL, opts.rule))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	item.Content = doc.Body
-	return nil
+	if n := textLength(a.Content); n < aMinTextLength.get() {
+		return nil, &extractError{fmt.Sprintf("%s got too little text(%d characters)", link, n)}
+	}
+	articles.Set(key, a)
+	return a, nil
 }
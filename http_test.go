@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhengchun/syndfeed"
+)
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc"`
+	modtime := time.Date(2020, 1, 2, 10, 0, 0, 500, time.UTC)
+	tests := []struct {
+		header map[string]string
+		want   bool
+	}{
+		{nil, false},
+		{map[string]string{"If-None-Match": `"abc"`}, true},
+		{map[string]string{"If-None-Match": `W/"abc"`}, true},
+		{map[string]string{"If-None-Match": `"x", "abc"`}, true},
+		{map[string]string{"If-None-Match": `*`}, true},
+		{map[string]string{"If-None-Match": `"x"`}, false},
+		{map[string]string{"If-Modified-Since": "Thu, 02 Jan 2020 10:00:00 GMT"}, true},
+		{map[string]string{"If-Modified-Since": "Thu, 02 Jan 2020 11:00:00 GMT"}, true},
+		{map[string]string{"If-Modified-Since": "Thu, 02 Jan 2020 09:59:59 GMT"}, false},
+		{map[string]string{"If-Modified-Since": "yesterday"}, false},
+		// If-None-Match takes precedence.
+		{map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": "Thu, 02 Jan 2020 11:00:00 GMT"}, false},
+		{map[string]string{"If-None-Match": `"abc"`, "If-Modified-Since": "Thu, 02 Jan 2020 09:00:00 GMT"}, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/feed", nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		if got := notModified(r, etag, modtime); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.header, got, tt.want)
+		}
+	}
+	r := httptest.NewRequest("GET", "/feed", nil)
+	r.Header.Set("If-Modified-Since", "Thu, 02 Jan 2020 11:00:00 GMT")
+	if notModified(r, etag, time.Time{}) {
+		t.Error("a feed without dates is not modified")
+	}
+}
+
+func TestWriteFeed(t *testing.T) {
+	date := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	feed := &fullFeed{
+		Feed: &syndfeed.Feed{
+			Title: "t",
+			Items: []*syndfeed.Item{{Title: "a", PublishDate: date}},
+		},
+		items: make(map[*syndfeed.Item]*itemExtension),
+	}
+	w := httptest.NewRecorder()
+	writeFeed(w, httptest.NewRequest("GET", "/feed", nil), feed, rss20Writer)
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || etag == "" || w.Body.Len() == 0 {
+		t.Fatalf("got %d, ETag %q and %d bytes", w.Code, etag, w.Body.Len())
+	}
+	if v := w.Header().Get("Last-Modified"); v != "Thu, 02 Jan 2020 10:00:00 GMT" {
+		t.Errorf("got Last-Modified %q", v)
+	}
+
+	w = httptest.NewRecorder()
+	writeFeed(w, httptest.NewRequest("HEAD", "/feed", nil), feed, rss20Writer)
+	if w.Code != 200 || w.Header().Get("ETag") != etag || w.Body.Len() != 0 {
+		t.Errorf("HEAD: got %d, ETag %q and %d bytes", w.Code, w.Header().Get("ETag"), w.Body.Len())
+	}
+
+	r := httptest.NewRequest("GET", "/feed", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	writeFeed(w, r, feed, rss20Writer)
+	if w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: got %d and %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		body  string
+		limit int64
+		ok    bool
+	}{
+		{"", 4, true},
+		{"abc", 4, true},
+		{"abcd", 4, true},
+		{"abcde", 4, false},
+		{strings.Repeat("a", 10000), 4096, false},
+	}
+	for _, tt := range tests {
+		r := &limitedReader{rc: ioutil.NopCloser(strings.NewReader(tt.body)), n: tt.limit, limit: tt.limit}
+		b, err := ioutil.ReadAll(r)
+		if (err == nil) != tt.ok {
+			t.Errorf("read %d bytes with limit %d: %v", len(tt.body), tt.limit, err)
+		}
+		if int64(len(b)) > tt.limit {
+			t.Errorf("read %d bytes over limit %d", len(b), tt.limit)
+		}
+		if tt.ok && string(b) != tt.body {
+			t.Errorf("read %q, want %q", b, tt.body)
+		}
+	}
+}
+
+func TestCheckHTML(t *testing.T) {
+	tests := []struct {
+		contentType string
+		ok          bool
+	}{
+		{"", true},
+		{"text/html", true},
+		{"text/html; charset=utf-8", true},
+		{"TEXT/HTML", true},
+		{"application/xhtml+xml", true},
+		{"application/pdf", false},
+		{"video/mp4", false},
+		{"text/plain", false},
+	}
+	u, _ := url.Parse("http://example.com/a")
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}, Request: &http.Request{URL: u}}
+		if tt.contentType != "" {
+			resp.Header.Set("Content-Type", tt.contentType)
+		}
+		if err := checkHTML(resp); (err == nil) != tt.ok {
+			t.Errorf("checkHTML(%q) = %v", tt.contentType, err)
+		}
+	}
+}
+
+func TestHTTPDoLimit(t *testing.T) {
+	useTestClient(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("a", 100)
+		if r.URL.Path == "/chunked" {
+			w.(http.Flusher).Flush()
+		} else {
+			w.Header().Set("Content-Length", "100")
+		}
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	if _, err := httpDo(context.Background(), ts.URL+"/sized", nil, 50); err == nil {
+		t.Error("got the body larger than the limit")
+	}
+	resp, err := httpDo(context.Background(), ts.URL+"/chunked", nil, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err == nil {
+		t.Error("read the body larger than the limit")
+	}
+	resp, err = httpDo(context.Background(), ts.URL+"/sized", nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, err := ioutil.ReadAll(resp.Body); err != nil || len(b) != 100 {
+		t.Errorf("read %d bytes, %v", len(b), err)
+	}
+}
+
+// newArticleSource serves a feed of an article, an article which waits
+// until its request is gone, and an item without link.
+func newArticleSource(t *testing.T) (*httptest.Server, chan struct{}) {
+	useTestClient(t)
+	setFlag(t, "retries", "0")
+	setFlag(t, "min-text-length", "1")
+	slow := make(chan struct{}, 1)
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprintf(w, `<rss version="2.0"><channel><title>t</title>`+
+				`<item><title>fast</title><link>%[1]s/fast</link><description>summary</description></item>`+
+				`<item><title>slow</title><link>%[1]s/slow</link><description>summary</description></item>`+
+				`<item><title>nolink</title><description>&lt;p&gt;summary&lt;/p&gt;</description></item>`+
+				`</channel></rss>`, ts.URL)
+		case "/fast":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><article><p>The full text of the article.</p></article></body></html>`))
+		case "/slow":
+			slow <- struct{}{}
+			<-r.Context().Done()
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, slow
+}
+
+func TestBuildFullFeedTimeout(t *testing.T) {
+	ts, _ := newArticleSource(t)
+	setFlag(t, "feed-timeout", "300ms")
+	opts := &feedOptions{source: ts.URL + "/feed", count: 10, workers: 2}
+	feed, err := buildFullFeed(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 3 {
+		t.Fatalf("got %d items", len(feed.Items))
+	}
+	fast, slow, nolink := feed.Items[0], feed.Items[1], feed.Items[2]
+	if ext := feed.ext(fast); !ext.fulltext || ext.failure != nil || !strings.Contains(fast.Content, "full text") {
+		t.Errorf("fast: fulltext %v, failure %v, content %q", ext.fulltext, ext.failure, fast.Content)
+	}
+	if ext := feed.ext(slow); ext.fulltext || ext.failure == nil || ext.failure.reason != failureTimeout || slow.Content != "" {
+		t.Errorf("slow: fulltext %v, failure %v, content %q", ext.fulltext, ext.failure, slow.Content)
+	}
+	// the item without link passes through untouched.
+	if ext := feed.ext(nolink); ext.fulltext || ext.failure != nil || nolink.Content != "" || nolink.Summary != "<p>summary</p>" {
+		t.Errorf("nolink: fulltext %v, failure %v, content %q, summary %q", ext.fulltext, ext.failure, nolink.Content, nolink.Summary)
+	}
+	if got := feed.failures(); got != "1; timeout=1" {
+		t.Errorf("got failures %q", got)
+	}
+}
+
+func TestBuildFullFeedCancel(t *testing.T) {
+	ts, slow := newArticleSource(t)
+	setFlag(t, "feed-timeout", "30s")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-slow
+		cancel()
+	}()
+	opts := &feedOptions{source: ts.URL + "/feed", count: 10, workers: 2}
+	feed, err := buildFullFeed(ctx, opts)
+	if err != context.Canceled || feed != nil {
+		t.Errorf("got %v, %v, want %v", feed, err, context.Canceled)
+	}
+}
+
+func TestAcceptsJSONFeed(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"application/rss+xml"}, false},
+		{[]string{"application/feed+json"}, true},
+		{[]string{"application/xml;q=0.9, application/feed+json"}, true},
+		{[]string{"application/feed+json;q=0.5"}, true},
+		{[]string{"Application/Feed+JSON"}, true},
+		{[]string{"application/feed+json;q=0"}, false},
+		{[]string{"application/feed+json; q=0.0"}, false},
+		{[]string{"application/feed+json;q=0.000, */*"}, false},
+		{[]string{"application/feed+json;q=abc"}, false},
+		{[]string{"application/json"}, false},
+		{[]string{"application/xml", "application/feed+json"}, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/feed", nil)
+		for _, v := range tt.accept {
+			r.Header.Add("Accept", v)
+		}
+		if got := acceptsJSONFeed(r); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// images is the disk cache of the image proxy.
+var images = newImageCache("", 0)
+
+// imageCache caches the proxied images on disk, the oldest used images
+// are removed when the total size is over size bytes. A cached file is
+// the content type in the first line and then the image data.
+type imageCache struct {
+	dir  string
+	size int64
+
+	mu    sync.Mutex
+	total int64
+}
+
+func newImageCache(dir string, size int64) *imageCache {
+	return &imageCache{dir: dir, size: size}
+}
+
+func (c *imageCache) filename(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Load creates the cache directory and counts the size of cached images.
+func (c *imageCache) Load() error {
+	if c.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fi := range files {
+		if isTempImage(fi.Name()) {
+			// left by a crash while writing.
+			os.Remove(filepath.Join(c.dir, fi.Name()))
+			continue
+		}
+		c.total += fi.Size()
+	}
+	c.evict()
+	return nil
+}
+
+// tempImagePrefix is the prefix of the temporary files of the images being
+// written, the cached files are named by the hash of the key.
+const tempImagePrefix = "tmp-"
+
+func isTempImage(name string) bool {
+	return strings.HasPrefix(name, tempImagePrefix)
+}
+
+// Get returns the cached image of key and its content type.
+func (c *imageCache) Get(key string) ([]byte, string, bool) {
+	if c.dir == "" {
+		return nil, "", false
+	}
+	name := c.filename(key)
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, "", false
+	}
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return nil, "", false
+	}
+	// keep the recently used images.
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	return b[i+1:], string(b[:i]), true
+}
+
+// Set stores the image of key.
+func (c *imageCache) Set(key string, b []byte, contentType string) {
+	if c.dir == "" || int64(len(b)) > c.size {
+		return
+	}
+	data := append([]byte(contentType+"\n"), b...)
+	// write a temporary file first, so Get never reads a partial image.
+	tmp, err := c.writeTemp(data)
+	if err != nil {
+		logrus.Warnf("image cache: write %s failed. %s", key, err)
+		return
+	}
+	name := c.filename(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var old int64
+	if fi, err := os.Stat(name); err == nil {
+		old = fi.Size()
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		logrus.Warnf("image cache: write %s failed. %s", key, err)
+		return
+	}
+	c.total += int64(len(data)) - old
+	c.evict()
+}
+
+func (c *imageCache) writeTemp(data []byte) (string, error) {
+	f, err := ioutil.TempFile(c.dir, tempImagePrefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// evict removes the oldest used images until the cache fits in size.
+func (c *imageCache) evict() {
+	if c.total <= c.size {
+		return
+	}
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	// the images being written are not counted yet.
+	list := files[:0]
+	for _, fi := range files {
+		if !isTempImage(fi.Name()) {
+			list = append(list, fi)
+		}
+	}
+	files = list
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	c.total = 0
+	for _, fi := range files {
+		c.total += fi.Size()
+	}
+	for _, fi := range files {
+		if c.total <= c.size {
+			break
+		}
+		if os.Remove(filepath.Join(c.dir, fi.Name())) == nil {
+			c.total -= fi.Size()
+		}
+	}
+}
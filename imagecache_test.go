@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestImageCacheSet(t *testing.T) {
+	dir := t.TempDir()
+	c := newImageCache(dir, 1000)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a", bytes.Repeat([]byte("a"), 100), "image/png")
+	b, contentType, ok := c.Get("a")
+	if !ok || len(b) != 100 || contentType != "image/png" {
+		t.Fatalf("Get(a) = %d bytes, %q, %v", len(b), contentType, ok)
+	}
+	// overwriting a key does not count its size twice.
+	for i := 0; i < 20; i++ {
+		c.Set("a", bytes.Repeat([]byte("b"), 100), "image/jpeg")
+	}
+	if want := int64(len("image/jpeg\n") + 100); c.total != want {
+		t.Errorf("total = %d, want %d", c.total, want)
+	}
+	if b, contentType, _ := c.Get("a"); b[0] != 'b' || contentType != "image/jpeg" {
+		t.Errorf("Get(a) = %q, %q", b[:1], contentType)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != filepath.Base(c.filename("a")) {
+		t.Errorf("got %d files in the cache directory", len(files))
+	}
+
+	// too large for the cache.
+	c.Set("b", bytes.Repeat([]byte("b"), 1001), "image/png")
+	if _, _, ok := c.Get("b"); ok {
+		t.Error("the image larger than the cache is cached")
+	}
+}
+
+func TestImageCacheConcurrent(t *testing.T) {
+	c := newImageCache(t.TempDir(), 1<<20)
+	data := bytes.Repeat([]byte("x"), 64<<10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c.Set("a", data, "image/png")
+		}()
+		go func() {
+			defer wg.Done()
+			// a partial image is never read.
+			if b, _, ok := c.Get("a"); ok && len(b) != len(data) {
+				t.Errorf("got %d bytes, want %d", len(b), len(data))
+			}
+		}()
+	}
+	wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if want := int64(len("image/png\n") + len(data)); c.total != want {
+		t.Errorf("total = %d, want %d", c.total, want)
+	}
+}
+
+func TestImageCacheLoad(t *testing.T) {
+	dir := t.TempDir()
+	c := newImageCache(dir, 1000)
+	c.Set("a", bytes.Repeat([]byte("a"), 100), "image/png")
+	// a temporary file left by a crash.
+	tmp := filepath.Join(dir, tempImagePrefix+"123")
+	if err := ioutil.WriteFile(tmp, []byte("image/png\npartial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c = newImageCache(dir, 1000)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len("image/png\n") + 100); c.total != want {
+		t.Errorf("total = %d, want %d", c.total, want)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("the temporary file is kept, %v", err)
+	}
+
+	// the oldest used images are removed over the size.
+	c = newImageCache(dir, 150)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("b", bytes.Repeat([]byte("b"), 100), "image/png")
+	if _, _, ok := c.Get("b"); !ok {
+		t.Error("the new image is evicted")
+	}
+	if _, _, ok := c.Get("a"); ok || c.total > 150 {
+		t.Errorf("total = %d, the oldest image is kept %v", c.total, ok)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+)
+
+// imageKey is the key to sign the URLs of the image proxy, so it can't
+// be used as an open proxy.
+var imageKey []byte
+
+func initImageKey(key string) {
+	if key != "" {
+		imageKey = []byte(key)
+		return
+	}
+	imageKey = make([]byte, 32)
+	rand.Read(imageKey)
+	logrus.Warn("image proxy: no -image-key, the image URLs are invalid after restart")
+}
+
+func signImage(src, referer string) string {
+	if len(imageKey) == 0 {
+		panic("image proxy: the key is not initialized")
+	}
+	mac := hmac.New(sha256.New, imageKey)
+	mac.Write([]byte(src + "\n" + referer))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// proxyImageURL returns the URL of src on the image proxy of base.
+func proxyImageURL(base, src, referer string) string {
+	q := url.Values{
+		"url": {src},
+		"ref": {referer},
+		"sig": {signImage(src, referer)},
+	}
+	return base + "/img?" + q.Encode()
+}
+
+// baseURL returns the URL of rss2full as the client requested.
+func baseURL(r *http.Request) string {
+	if aBaseURL.get() != "" {
+		return strings.TrimSuffix(aBaseURL.get(), "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// proxyImages points every image of the items at the image proxy, the
+// lead images as well as those of the content.
+func proxyImages(feed *fullFeed, base string) {
+	for _, item := range feed.Items {
+		if len(item.Links) == 0 {
+			continue
+		}
+		referer := item.Links[0].URL
+		if ext := feed.ext(item); isHTTPURL(ext.image) {
+			ext.image = proxyImageURL(base, ext.image, referer)
+		}
+		if item.Content == "" {
+			continue
+		}
+		nodes, err := parseFragment(item.Content)
+		if err != nil {
+			continue
+		}
+		var b strings.Builder
+		for _, n := range nodes {
+			for _, img := range findElements(n, "img", "source") {
+				for i, attr := range img.Attr {
+					switch attr.Key {
+					case "src":
+						if isHTTPURL(attr.Val) {
+							img.Attr[i].Val = proxyImageURL(base, attr.Val, referer)
+						}
+					case "srcset":
+						var list []string
+						for _, c := range strings.Split(attr.Val, ",") {
+							fields := strings.Fields(c)
+							if len(fields) > 0 && isHTTPURL(fields[0]) {
+								fields[0] = proxyImageURL(base, fields[0], referer)
+							}
+							list = append(list, strings.Join(fields, " "))
+						}
+						img.Attr[i].Val = strings.Join(list, ", ")
+					}
+				}
+			}
+			html.Render(&b, n)
+		}
+		item.Content = b.String()
+	}
+}
+
+func findElements(n *html.Node, tags ...string) []*html.Node {
+	var list []*html.Node
+	if n.Type == html.ElementNode && contains(tags, n.Data) {
+		list = append(list, n)
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		list = append(list, findElements(child, tags...)...)
+	}
+	return list
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// imageTypes are the types of the images which are proxied. The other
+// types such as SVG may run scripts on the origin of rss2full.
+var imageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// maxResizePixels is the max pixels of an image to resize, the larger
+// images are served as they are.
+const maxResizePixels = 1 << 24
+
+// ImageProxy serves an image of the article through rss2full, with the
+// article URL as Referer.
+func ImageProxy(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if len(imageKey) == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	q := r.URL.Query()
+	src, referer := q.Get("url"), q.Get("ref")
+	if !isHTTPURL(src) || !hmac.Equal([]byte(q.Get("sig")), []byte(signImage(src, referer))) {
+		w.WriteHeader(403)
+		w.Write([]byte("Invalid image signature"))
+		return
+	}
+	b, contentType, ok := images.Get(src)
+	if !ok || !contains(imageTypes, contentType) {
+		var err error
+		b, contentType, err = fetchImage(r.Context(), src, referer)
+		if err != nil {
+			logrus.Warnf("GET %s failed. %s", src, err)
+			w.WriteHeader(502)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		images.Set(src, b, contentType)
+	}
+	w.Header().Set("Content-Type", contentType)
+	// the image of a URL never changes.
+	w.Header().Set("Cache-Control", "public, max-age=2592000")
+	w.Write(b)
+}
+
+func fetchImage(ctx context.Context, src, referer string) ([]byte, string, error) {
+	header := make(http.Header)
+	if referer != "" {
+		header.Set("Referer", referer)
+	}
+	resp, err := httpDo(ctx, src, header, aMaxImageSize.get()<<20)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, "", fmt.Errorf("%s got status-code is not 200(%d)", src, resp.StatusCode)
+	}
+	if mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); !strings.HasPrefix(mediatype, "image/") {
+		return nil, "", fmt.Errorf("%s got mediatype is not image(%s)", src, mediatype)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	// the type is sniffed from the data, the Content-Type of the upstream
+	// is not trusted.
+	contentType := http.DetectContentType(b)
+	if !contains(imageTypes, contentType) {
+		return nil, "", fmt.Errorf("%s got image type is not supported(%s)", src, contentType)
+	}
+	if aImageMaxWidth.get() > 0 {
+		if v, t, err := resizeImage(b, aImageMaxWidth.get()); err == nil {
+			b, contentType = v, t
+		}
+	}
+	return b, contentType, nil
+}
+
+// resizeImage scales a JPEG or PNG image down to width and re-encodes
+// it, the other formats such as animated GIF and the images over
+// maxResizePixels are returned as they are.
+func resizeImage(b []byte, width int) ([]byte, string, error) {
+	conf, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	if (format != "jpeg" && format != "png") || conf.Width <= width || conf.Width*conf.Height > maxResizePixels {
+		return nil, "", fmt.Errorf("%s image is not resized", format)
+	}
+	src, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	dst := scaleImage(src, width)
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/" + format, nil
+}
+
+// scaleImage scales src down to width with box filter.
+func scaleImage(src image.Image, width int) image.Image {
+	sb := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		// the decoded images are YCbCr, NRGBA, Paletted and so on, which
+		// are converted by the fast paths of draw.
+		rgba = image.NewRGBA(image.Rect(0, 0, sb.Dx(), sb.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, sb.Min, draw.Src)
+		sb = rgba.Bounds()
+	}
+	height := sb.Dy() * width / sb.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := sb.Min.Y + y*sb.Dy()/height
+		y1 := sb.Min.Y + (y+1)*sb.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := sb.Min.X + x*sb.Dx()/width
+			x1 := sb.Min.X + (x+1)*sb.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[rgba.PixOffset(x0, sy):rgba.PixOffset(x1, sy)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
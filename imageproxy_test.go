@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zhengchun/syndfeed"
+)
+
+func TestImageProxyDisabled(t *testing.T) {
+	imageKey = nil
+	w := httptest.NewRecorder()
+	ImageProxy(w, httptest.NewRequest("GET", "/img?url=http://example.com/a.png&sig=x", nil), nil)
+	if w.Code != 404 {
+		t.Fatalf("got %d, want 404", w.Code)
+	}
+	if v := w.Header().Get("X-Content-Type-Options"); v != "nosniff" {
+		t.Fatalf("got X-Content-Type-Options %q", v)
+	}
+}
+
+func TestImageProxySignature(t *testing.T) {
+	initImageKey("k")
+	defer func() { imageKey = nil }()
+	w := httptest.NewRecorder()
+	ImageProxy(w, httptest.NewRequest("GET", "/img?url=http://example.com/a.png&sig=00", nil), nil)
+	if w.Code != 403 {
+		t.Fatalf("got %d, want 403", w.Code)
+	}
+}
+
+func TestProxyImages(t *testing.T) {
+	initImageKey("k")
+	defer func() { imageKey = nil }()
+	item := &syndfeed.Item{
+		Links:   []*syndfeed.Link{{URL: "http://example.com/a"}},
+		Content: `<p><img src="http://example.com/b.png"/></p>`,
+	}
+	feed := &fullFeed{Feed: &syndfeed.Feed{Items: []*syndfeed.Item{item}}}
+	feed.ext(item).image = "http://example.com/lead.png"
+	proxyImages(feed, "http://rss2full")
+
+	want := proxyImageURL("http://rss2full", "http://example.com/b.png", "http://example.com/a")
+	if !strings.Contains(item.Content, strings.Replace(want, "&", "&amp;", -1)) {
+		t.Errorf("got content %q", item.Content)
+	}
+	want = proxyImageURL("http://rss2full", "http://example.com/lead.png", "http://example.com/a")
+	if got := feed.ext(item).thumbnail(); got != want {
+		t.Errorf("got lead image %q, want %q", got, want)
+	}
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			src.Set(x, y, color.NRGBA{200, 100, 50, 255})
+		}
+	}
+	b, contentType, err := resizeImage(encodePNG(t, src), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/png" {
+		t.Fatalf("got %s", contentType)
+	}
+	dst, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.Bounds(); got.Dx() != 10 || got.Dy() != 5 {
+		t.Fatalf("got size %v, want 10x5", got)
+	}
+	if r, g, b, _ := dst.At(5, 2).RGBA(); r>>8 != 200 || g>>8 != 100 || b>>8 != 50 {
+		t.Fatalf("got color %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+
+	if _, _, err := resizeImage(encodePNG(t, src), 40); err == nil {
+		t.Fatal("the image which is not wider than width is resized")
+	}
+}
+
+func TestResizeImageTooLarge(t *testing.T) {
+	// the header declares 50000x50000 pixels, it must not be decoded.
+	b := encodePNG(t, image.NewGray(image.Rect(0, 0, 1, 1)))
+	b[16], b[17], b[18], b[19] = 0, 0, 0xc3, 0x50
+	b[20], b[21], b[22], b[23] = 0, 0, 0xc3, 0x50
+	binary.BigEndian.PutUint32(b[29:], crc32.ChecksumIEEE(b[12:29]))
+	conf, err := png.DecodeConfig(bytes.NewReader(b))
+	if err != nil || conf.Width != 50000 {
+		t.Fatalf("got %v, %v", conf, err)
+	}
+	if _, _, err := resizeImage(b, 100); err == nil {
+		t.Fatal("the image over maxResizePixels is resized")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"golang.org/x/net/html"
+)
+
+var (
+	// lazySrcAttrs are the attributes of the real image URL of
+	// lazy-loaded images, src is a placeholder then.
+	lazySrcAttrs = []string{"data-src", "data-lazy-src", "data-original", "data-lazy", "data-url", "data-actualsrc"}
+	// lazySrcsetAttrs are the attributes of the real srcset.
+	lazySrcsetAttrs = []string{"data-srcset", "data-lazy-srcset"}
+)
+
+func getAttr(n *html.Node, keys ...string) string {
+	for _, key := range keys {
+		for _, attr := range n.Attr {
+			if attr.Key == key && strings.TrimSpace(attr.Val) != "" {
+				return strings.TrimSpace(attr.Val)
+			}
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttrs(n *html.Node, keys ...string) {
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		if !contains(keys, attr.Key) {
+			attrs = append(attrs, attr)
+		}
+	}
+	n.Attr = attrs
+}
+
+// promoteImages makes the lazy-loaded images of htmlDoc real images
+// before extraction, which keeps src only.
+func promoteImages(htmlDoc *html.Node) {
+	for _, n := range htmlquery.Find(htmlDoc, "//noscript") {
+		unwrapNoscript(n)
+	}
+	for _, n := range htmlquery.Find(htmlDoc, "//img|//picture/source") {
+		if v := getAttr(n, lazySrcAttrs...); v != "" {
+			setAttr(n, "src", v)
+		}
+		if v := getAttr(n, lazySrcsetAttrs...); v != "" {
+			setAttr(n, "srcset", v)
+		}
+		removeAttrs(n, append(lazySrcAttrs, lazySrcsetAttrs...)...)
+		if n.Data != "img" {
+			continue
+		}
+		src := getAttr(n, "src")
+		if src == "" || strings.HasPrefix(src, "data:") {
+			if v := largestSrcset(getAttr(n, "srcset")); v != "" {
+				setAttr(n, "src", v)
+			}
+		}
+	}
+}
+
+// unwrapNoscript replaces <noscript> with its images, which are the
+// fallback of the lazy-loaded image before it.
+func unwrapNoscript(n *html.Node) {
+	if n.Parent == nil {
+		return
+	}
+	nodes, err := parseFragment(htmlquery.InnerText(n))
+	if err != nil {
+		return
+	}
+	var hasImage bool
+	for _, v := range nodes {
+		if v.Type == html.ElementNode && (v.Data == "img" || htmlquery.FindOne(v, "//img") != nil) {
+			hasImage = true
+		}
+	}
+	if !hasImage {
+		return
+	}
+	prev := n.PrevSibling
+	for prev != nil && prev.Type == html.TextNode && strings.TrimSpace(prev.Data) == "" {
+		prev = prev.PrevSibling
+	}
+	if prev != nil && prev.Type == html.ElementNode && prev.Data == "img" {
+		n.Parent.RemoveChild(prev)
+	}
+	for _, v := range nodes {
+		n.Parent.InsertBefore(v, n)
+	}
+	n.Parent.RemoveChild(n)
+}
+
+// largestSrcset returns the URL of the largest candidate of srcset.
+func largestSrcset(srcset string) string {
+	var (
+		best  string
+		width float64
+	)
+	for _, c := range strings.Split(srcset, ",") {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		w := 1.0
+		if len(fields) > 1 {
+			if v, err := strconv.ParseFloat(strings.TrimRight(fields[1], "wx"), 64); err == nil {
+				w = v
+			}
+		}
+		if best == "" || w > width {
+			best, width = fields[0], w
+		}
+	}
+	return best
+}
+
+// normalizeContent resolves every src, srcset and href of the content
+// against u, and removes the tracking pixels.
+func normalizeContent(u *url.URL, content string) string {
+	nodes, err := parseFragment(content)
+	if err != nil {
+		return content
+	}
+	var walk func(n *html.Node) bool
+	walk = func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		if n.Data == "img" && isTrackingPixel(n) {
+			return false
+		}
+		for i, attr := range n.Attr {
+			switch attr.Key {
+			case "href", "src":
+				if v, err := u.Parse(strings.TrimSpace(attr.Val)); err == nil {
+					n.Attr[i].Val = v.String()
+				}
+			case "srcset":
+				n.Attr[i].Val = resolveSrcset(u, attr.Val)
+			}
+		}
+		for child := n.FirstChild; child != nil; {
+			next := child.NextSibling
+			if !walk(child) {
+				n.RemoveChild(child)
+			}
+			child = next
+		}
+		return true
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		if walk(n) {
+			html.Render(&b, n)
+		}
+	}
+	return b.String()
+}
+
+func resolveSrcset(u *url.URL, srcset string) string {
+	var list []string
+	for _, c := range strings.Split(srcset, ",") {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := u.Parse(fields[0]); err == nil {
+			fields[0] = v.String()
+		}
+		list = append(list, strings.Join(fields, " "))
+	}
+	return strings.Join(list, ", ")
+}
+
+// isTrackingPixel reports whether the image is a 1x1 tracking pixel.
+func isTrackingPixel(n *html.Node) bool {
+	w, err1 := strconv.Atoi(getAttr(n, "width"))
+	h, err2 := strconv.Atoi(getAttr(n, "height"))
+	return err1 == nil && err2 == nil && w <= 1 && h <= 1
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/htmlquery"
+)
+
+func TestLargestSrcset(t *testing.T) {
+	tests := []struct {
+		srcset string
+		want   string
+	}{
+		{"", ""},
+		{"a.jpg", "a.jpg"},
+		{"a.jpg 320w, b.jpg 1024w, c.jpg 640w", "b.jpg"},
+		{"a.jpg 1x, b.jpg 2x", "b.jpg"},
+		{" a.jpg , b.jpg 2x ,", "b.jpg"},
+		{"a.jpg 100w, b.jpg bad", "a.jpg"},
+	}
+	for _, tt := range tests {
+		if got := largestSrcset(tt.srcset); got != tt.want {
+			t.Errorf("largestSrcset(%q) = %q, want %q", tt.srcset, got, tt.want)
+		}
+	}
+}
+
+func TestPromoteImages(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<img src="a.jpg">`, `<img src="a.jpg"/>`},
+		{`<img src="blank.gif" data-src="a.jpg">`, `<img src="a.jpg"/>`},
+		{`<img data-lazy-src="a.jpg" data-srcset="a.jpg 1x, b.jpg 2x">`, `<img src="a.jpg" srcset="a.jpg 1x, b.jpg 2x"/>`},
+		{`<img src="data:image/gif;base64,R0lGOD" srcset="a.jpg 320w, b.jpg 640w">`, `<img src="b.jpg" srcset="a.jpg 320w, b.jpg 640w"/>`},
+		{`<picture><source data-srcset="a.webp"><img data-src="a.jpg"></picture>`, `<picture><source srcset="a.webp"/><img src="a.jpg"/></picture>`},
+		// the <noscript> fallback replaces the placeholder before it.
+		{`<img src="blank.gif"> <noscript><img src="a.jpg"></noscript>`, ` <img src="a.jpg"/>`},
+		{`<p>text</p><noscript><p>enable JavaScript</p></noscript>`, `<p>text</p><noscript><p>enable JavaScript</p></noscript>`},
+	}
+	for _, tt := range tests {
+		htmlDoc, err := htmlquery.Parse(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		promoteImages(htmlDoc)
+		if got := htmlquery.OutputHTML(htmlquery.FindOne(htmlDoc, "//body"), false); got != tt.want {
+			t.Errorf("promoteImages(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeContent(t *testing.T) {
+	u, _ := url.Parse("http://example.com/posts/a")
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`<a href="b">b</a>`, `<a href="http://example.com/posts/b">b</a>`},
+		{`<img src="/img/a.jpg"/>`, `<img src="http://example.com/img/a.jpg"/>`},
+		{`<img src=" //cdn.example.com/a.jpg "/>`, `<img src="http://cdn.example.com/a.jpg"/>`},
+		{`<img srcset="a.jpg 1x,  /b.jpg 2x"/>`, `<img srcset="http://example.com/posts/a.jpg 1x, http://example.com/b.jpg 2x"/>`},
+		{`<p>text<img src="pixel.gif" width="1" height="1"/></p>`, `<p>text</p>`},
+		{`<img src="a.gif" width="1" height="40"/>`, `<img src="http://example.com/posts/a.gif" width="1" height="40"/>`},
+		{`<a href="https://example.org/">x</a>`, `<a href="https://example.org/">x</a>`},
+	}
+	for _, tt := range tests {
+		if got := normalizeContent(u, tt.content); got != tt.want {
+			t.Errorf("normalizeContent(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/zhengchun/syndfeed"
+)
+
+// https://www.jsonfeed.org/version/1.1/
+type jsonFeed struct {
+	Version     string        `json:"version"`
+	Title       string        `json:"title"`
+	HomePageURL string        `json:"home_page_url,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Icon        string        `json:"icon,omitempty"`
+	Authors     []*jsonAuthor `json:"authors,omitempty"`
+	Language    string        `json:"language,omitempty"`
+	NextURL     string        `json:"next_url,omitempty"`
+	Items       []*jsonItem   `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+type jsonItem struct {
+	ID            string            `json:"id"`
+	URL           string            `json:"url,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	ContentHTML   string            `json:"content_html"`
+	Summary       string            `json:"summary,omitempty"`
+	Image         string            `json:"image,omitempty"`
+	DatePublished string            `json:"date_published,omitempty"`
+	DateModified  string            `json:"date_modified,omitempty"`
+	Authors       []*jsonAuthor     `json:"authors,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Attachments   []*jsonAttachment `json:"attachments,omitempty"`
+	// Extension is the extension object of rss2full.
+	Extension *jsonExtension `json:"_rss2full,omitempty"`
+}
+
+type jsonExtension struct {
+	Failure *jsonFailure `json:"failure,omitempty"`
+}
+
+type jsonFailure struct {
+	Reason  string `json:"reason"`
+	Status  int    `json:"status,omitempty"`
+	Message string `json:"message"`
+}
+
+type jsonAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+func jsonDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func jsonAuthors(persons []*syndfeed.Person) []*jsonAuthor {
+	var authors []*jsonAuthor
+	for _, v := range persons {
+		url := v.URL
+		if url == "" && v.Email != "" {
+			url = "mailto:" + v.Email
+		}
+		authors = append(authors, &jsonAuthor{Name: v.Name, URL: url})
+	}
+	return authors
+}
+
+func outputJSONFeed(w io.Writer, feed *fullFeed) error {
+	f := &jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		Description: feed.Description,
+		Icon:        feed.ImageURL,
+		Authors:     jsonAuthors(feed.Authors),
+		Language:    feed.Language,
+		Items:       make([]*jsonItem, 0, len(feed.Items)),
+	}
+	if len(feed.Links) > 0 {
+		f.HomePageURL = feed.Links[0].URL
+	}
+	for _, v := range feed.archiveLinks {
+		if v.RelType == "next" {
+			f.NextURL = v.URL
+		}
+	}
+	for _, item := range feed.Items {
+		v := &jsonItem{
+			ID:            item.Id,
+			Title:         item.Title,
+			ContentHTML:   item.Content,
+			Summary:       item.Summary,
+			Image:         feed.ext(item).image,
+			DatePublished: jsonDate(item.PublishDate),
+			DateModified:  jsonDate(item.LastUpdatedTime),
+			Authors:       jsonAuthors(item.Authors),
+			Tags:          item.Categories,
+		}
+		if len(item.Links) > 0 {
+			v.URL = item.Links[0].URL
+		}
+		if v.ID == "" {
+			v.ID = v.URL
+		}
+		for _, e := range feed.enclosures(item) {
+			size, _ := strconv.ParseInt(e.Length, 10, 64)
+			v.Attachments = append(v.Attachments, &jsonAttachment{URL: e.URL, MimeType: e.Type, SizeInBytes: size})
+		}
+		if fail := feed.ext(item).failure; fail != nil {
+			v.Extension = &jsonExtension{Failure: &jsonFailure{
+				Reason:  fail.reason,
+				Status:  fail.status,
+				Message: fail.message,
+			}}
+		}
+		// content_html is required, the summary is better than nothing.
+		if v.ContentHTML == "" {
+			v.ContentHTML = item.Summary
+		}
+		f.Items = append(f.Items, v)
+	}
+	return json.NewEncoder(w).Encode(f)
+}
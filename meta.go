@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"golang.org/x/net/html"
+)
+
+// parseMetadata parses the metadata of an article page from JSON-LD,
+// OpenGraph, Twitter cards and the meta tags, in that order.
+func parseMetadata(u *url.URL, htmlDoc *html.Node) *article {
+	a := new(article)
+	for _, n := range htmlquery.Find(htmlDoc, `//script[@type='application/ld+json']`) {
+		var v interface{}
+		if err := json.Unmarshal([]byte(htmlquery.InnerText(n)), &v); err == nil {
+			parseJSONLD(a, v)
+		}
+	}
+	metas := make(map[string][]string)
+	for _, n := range htmlquery.Find(htmlDoc, "//meta[@content]") {
+		key := htmlquery.SelectAttr(n, "property")
+		if key == "" {
+			key = htmlquery.SelectAttr(n, "name")
+		}
+		if v := strings.TrimSpace(htmlquery.SelectAttr(n, "content")); v != "" {
+			metas[strings.ToLower(key)] = append(metas[strings.ToLower(key)], v)
+		}
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if v := metas[key]; len(v) > 0 {
+				return v[0]
+			}
+		}
+		return ""
+	}
+	if a.Title == "" {
+		a.Title = first("og:title", "twitter:title")
+	}
+	if len(a.Authors) == 0 {
+		// article:author is the URL of profile page usually.
+		if v := first("author", "article:author", "twitter:creator"); v != "" && !isHTTPURL(v) {
+			a.Authors = []string{v}
+		}
+	}
+	if a.Published.IsZero() {
+		a.Published, _ = parseDate(first("article:published_time", "datepublished", "date"))
+	}
+	if a.Updated.IsZero() {
+		a.Updated, _ = parseDate(first("article:modified_time", "og:updated_time", "datemodified"))
+	}
+	if len(a.Categories) == 0 {
+		a.Categories = metas["article:tag"]
+	}
+	if a.Image == "" {
+		a.Image = first("og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src")
+	}
+	if a.Image != "" {
+		if v, err := u.Parse(a.Image); err == nil {
+			a.Image = v.String()
+		}
+	}
+	return a
+}
+
+var jsonLDArticleTypes = []string{
+	"Article", "NewsArticle", "BlogPosting", "ReportageNewsArticle", "AnalysisNewsArticle", "OpinionNewsArticle", "TechArticle", "ScholarlyArticle",
+}
+
+// parseJSONLD fills a with the first schema.org article of v.
+func parseJSONLD(a *article, v interface{}) bool {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, v := range v {
+			if parseJSONLD(a, v) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		if graph, ok := v["@graph"]; ok {
+			return parseJSONLD(a, graph)
+		}
+		var isArticle bool
+		for _, t := range jsonLDStrings(v["@type"]) {
+			isArticle = isArticle || contains(jsonLDArticleTypes, t)
+		}
+		if !isArticle {
+			return false
+		}
+		if s := jsonLDStrings(v["headline"]); len(s) > 0 {
+			a.Title = s[0]
+		}
+		a.Authors = jsonLDNames(v["author"])
+		if s := jsonLDStrings(v["datePublished"]); len(s) > 0 {
+			a.Published, _ = parseDate(s[0])
+		}
+		if s := jsonLDStrings(v["dateModified"]); len(s) > 0 {
+			a.Updated, _ = parseDate(s[0])
+		}
+		for _, s := range append(jsonLDStrings(v["articleSection"]), jsonLDStrings(v["keywords"])...) {
+			for _, s := range strings.Split(s, ",") {
+				if s = strings.TrimSpace(s); s != "" && !contains(a.Categories, s) {
+					a.Categories = append(a.Categories, s)
+				}
+			}
+		}
+		if s := jsonLDURLs(v["image"]); len(s) > 0 {
+			a.Image = s[0]
+		}
+		return true
+	}
+	return false
+}
+
+// jsonLDStrings returns a string or an array of strings.
+func jsonLDStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, v := range v {
+			if s, ok := v.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// jsonLDNames returns the names of a Person, an array of Person or a string.
+func jsonLDNames(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case map[string]interface{}:
+		return jsonLDStrings(v["name"])
+	case []interface{}:
+		var list []string
+		for _, v := range v {
+			list = append(list, jsonLDNames(v)...)
+		}
+		return list
+	}
+	return nil
+}
+
+// jsonLDURLs returns the URLs of a URL, an ImageObject or an array of them.
+func jsonLDURLs(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case map[string]interface{}:
+		return jsonLDStrings(v["url"])
+	case []interface{}:
+		var list []string
+		for _, v := range v {
+			list = append(list, jsonLDURLs(v)...)
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antchfx/htmlquery"
+)
+
+func TestParseJSONLD(t *testing.T) {
+	published := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		json string
+		ok   bool
+		want article
+	}{
+		{`{"@type": "WebSite", "name": "site"}`, false, article{}},
+		{`{"@type": "NewsArticle", "headline": "Title", "author": {"@type": "Person", "name": "Alice"},
+			"datePublished": "2018-05-01T10:00:00Z", "image": {"@type": "ImageObject", "url": "http://example.com/a.jpg"}}`,
+			true, article{Title: "Title", Authors: []string{"Alice"}, Published: published, Image: "http://example.com/a.jpg"}},
+		{`{"@type": ["CreativeWork", "BlogPosting"], "headline": ["Title"], "author": [{"name": "Alice"}, "Bob"],
+			"articleSection": "News", "keywords": "go, rss, News", "image": ["http://example.com/a.jpg", "http://example.com/b.jpg"]}`,
+			true, article{Title: "Title", Authors: []string{"Alice", "Bob"}, Categories: []string{"News", "go", "rss"}, Image: "http://example.com/a.jpg"}},
+		{`{"@graph": [{"@type": "WebPage"}, {"@type": "Article", "headline": "Title"}]}`, true, article{Title: "Title"}},
+		{`[{"@type": "Organization"}, {"@type": "TechArticle", "headline": "Title"}]`, true, article{Title: "Title"}},
+		{`"Article"`, false, article{}},
+	}
+	for _, tt := range tests {
+		var v interface{}
+		if err := json.Unmarshal([]byte(tt.json), &v); err != nil {
+			t.Fatal(err)
+		}
+		var a article
+		if ok := parseJSONLD(&a, v); ok != tt.ok || !reflect.DeepEqual(a, tt.want) {
+			t.Errorf("parseJSONLD(%s) = %+v, %v, want %+v, %v", tt.json, a, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	u, _ := url.Parse("http://example.com/posts/a")
+	tests := []struct {
+		html string
+		want article
+	}{
+		{`<p>no metadata</p>`, article{}},
+		{`<meta property="og:title" content=" OG title "><meta name="twitter:title" content="Twitter title">
+			<meta property="og:image" content="/a.jpg"><meta name="author" content="Alice">
+			<meta property="article:published_time" content="2018-05-01T10:00:00Z">
+			<meta property="article:tag" content="go"><meta property="article:tag" content="rss">`,
+			article{Title: "OG title", Authors: []string{"Alice"}, Published: time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC),
+				Categories: []string{"go", "rss"}, Image: "http://example.com/a.jpg"}},
+		// the profile URL of article:author is not a name.
+		{`<meta property="article:author" content="https://example.com/alice"><meta name="twitter:creator" content="@alice">`,
+			article{}},
+		{`<meta name="twitter:creator" content="@alice"><meta name="twitter:image:src" content="//cdn.example.com/a.jpg">`,
+			article{Authors: []string{"@alice"}, Image: "http://cdn.example.com/a.jpg"}},
+		// JSON-LD wins over the meta tags, which fill the rest.
+		{`<script type="application/ld+json">{"@type": "Article", "headline": "LD title"}</script>
+			<script type="application/ld+json">{not json</script>
+			<meta property="og:title" content="OG title"><meta name="author" content="Alice">`,
+			article{Title: "LD title", Authors: []string{"Alice"}}},
+	}
+	for _, tt := range tests {
+		htmlDoc, err := htmlquery.Parse(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parseMetadata(u, htmlDoc); !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("parseMetadata(%q) = %+v, want %+v", tt.html, *got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Metrics outputs the counters of rss2full in the Prometheus text format.
+func Metrics(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP rss2full_calls_total Number of the fetches and extractions requested.")
+	fmt.Fprintln(w, "# TYPE rss2full_calls_total counter")
+	for _, g := range flightGroups {
+		fmt.Fprintf(w, "rss2full_calls_total{kind=%q} %d\n", g.name, atomic.LoadInt64(&g.total))
+	}
+	fmt.Fprintln(w, "# HELP rss2full_coalesced_total Number of the calls which shared the result of a concurrent call.")
+	fmt.Fprintln(w, "# TYPE rss2full_coalesced_total counter")
+	for _, g := range flightGroups {
+		fmt.Fprintf(w, "rss2full_coalesced_total{kind=%q} %d\n", g.name, atomic.LoadInt64(&g.coalesced))
+	}
+	fmt.Fprintln(w, "# HELP rss2full_retries_total Number of the retries of failed requests.")
+	fmt.Fprintln(w, "# TYPE rss2full_retries_total counter")
+	fmt.Fprintf(w, "rss2full_retries_total %d\n", atomic.LoadInt64(&retries))
+	fmt.Fprintln(w, "# HELP rss2full_open_circuits Number of the hosts which are not requested for failures.")
+	fmt.Fprintln(w, "# TYPE rss2full_open_circuits gauge")
+	fmt.Fprintf(w, "rss2full_open_circuits %d\n", breakers.open())
+}
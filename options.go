@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xpath"
+)
+
+// feedOptions are the options of a feed request. The legacy form
+// /feed/<url> always uses the defaults of the feed, the query form
+//
+//	/feed?url=<url>&selector=//article&strip=//aside&count=25&workers=4&format=atom
+//
+// overrides them per request, within the server limits.
+type feedOptions struct {
+	source  string
+	count   int
+	workers int
+	writer  feedWriter
+	// rule overrides the site rule, nil if not overridden.
+	rule *siteRule
+	// ruleKey identifies rule in the article cache.
+	ruleKey string
+	// page is the page of the history, archive is the archive document
+	// or -1, see archiveStore.View.
+	page    int
+	archive int
+}
+
+// parseFeedOptions parses the options of r, fw is the writer by the route.
+func parseFeedOptions(r *http.Request, fw feedWriter) (*feedOptions, error) {
+	opts := &feedOptions{
+		count:   aItemCount.get(),
+		workers: aConnectionPerFeed.get(),
+		writer:  fw,
+		page:    1,
+		archive: -1,
+	}
+	if strings.Count(r.URL.Path, "/") > 1 {
+		// skip a /feed/, /atom/ or /json/ segment.
+		source := r.URL.String()[1:]
+		source = source[strings.Index(source, "/")+1:]
+		// decode
+		opts.source, _ = url.QueryUnescape(source)
+		opts.count, opts.workers, opts.rule, opts.ruleKey = feedDefaults(opts.source)
+		return opts, validateSource(opts.source)
+	}
+
+	q := r.URL.Query()
+	opts.source = q.Get("url")
+	if err := validateSource(opts.source); err != nil {
+		return nil, err
+	}
+	count, workers, rule, ruleKey := feedDefaults(opts.source)
+	var err error
+	maxCount := aMaxItemCount.get()
+	if count > maxCount {
+		maxCount = count
+	}
+	if opts.count, err = queryInt(q, "count", count, maxCount); err != nil {
+		return nil, err
+	}
+	if opts.workers, err = queryInt(q, "workers", workers, workers); err != nil {
+		return nil, err
+	}
+	switch format := q.Get("format"); format {
+	case "":
+	case "rss":
+		opts.writer = rss20Writer
+	case "atom":
+		opts.writer = atomWriter
+	case "json":
+		opts.writer = jsonWriter
+	default:
+		return nil, fmt.Errorf("Invalid format(%s), must be rss, atom or json", format)
+	}
+	if opts.rule, opts.ruleKey, err = overrideRule(q); err != nil {
+		return nil, err
+	}
+	if opts.rule == nil {
+		opts.rule, opts.ruleKey = rule, ruleKey
+	}
+	if s := q.Get("page"); s != "" {
+		if opts.page, err = strconv.Atoi(s); err != nil || opts.page < 1 {
+			return nil, fmt.Errorf("Invalid page(%s), must be 1 or more", s)
+		}
+	}
+	if s := q.Get("archive"); s != "" {
+		if opts.archive, err = strconv.Atoi(s); err != nil || opts.archive < 0 {
+			return nil, fmt.Errorf("Invalid archive(%s), must be 0 or more", s)
+		}
+	}
+	return opts, nil
+}
+
+// overrideRule compiles the selector and strip of q into a site rule,
+// and returns the key of rule, which can be parsed by overrideRule again.
+func overrideRule(q url.Values) (*siteRule, string, error) {
+	if len(q["selector"]) == 0 && len(q["strip"]) == 0 {
+		return nil, "", nil
+	}
+	var (
+		rule = new(siteRule)
+		err  error
+	)
+	if rule.body, err = compileExprs(q["selector"]); err != nil {
+		return nil, "", err
+	}
+	if rule.strip, err = compileExprs(q["strip"]); err != nil {
+		return nil, "", err
+	}
+	return rule, url.Values{"selector": q["selector"], "strip": q["strip"]}.Encode(), nil
+}
+
+// feedDefaults returns the default count, workers and rule of source, by
+// its feed config if any.
+func feedDefaults(source string) (count, workers int, rule *siteRule, ruleKey string) {
+	count, workers = aItemCount.get(), aConnectionPerFeed.get()
+	fc := feedConfigFor(source)
+	if fc == nil {
+		return
+	}
+	if fc.Count > 0 {
+		count = fc.Count
+	}
+	if fc.Workers > 0 {
+		workers = fc.Workers
+	}
+	return count, workers, fc.rule, fc.ruleKey
+}
+
+func validateSource(source string) error {
+	if source == "" || !(strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")) {
+		return fmt.Errorf("Invalid source feed(%s)", source)
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return fmt.Errorf("Invalid source feed(%s)", source)
+	}
+	return guard.checkURL(u)
+}
+
+// queryInt returns the integer parameter name, which must be between 1
+// and max, or def if it is not set.
+func queryInt(q url.Values, name string, def, max int) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("Invalid %s(%s), must be between 1 and %d", name, s, max)
+	}
+	return n, nil
+}
+
+func compileExprs(list []string) ([]*xpath.Expr, error) {
+	var exprs []*xpath.Expr
+	for _, s := range list {
+		expr, err := xpath.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid XPath(%s). %s", s, err)
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
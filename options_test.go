@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestQueryInt(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{"", 10, false},
+		{"count=1", 1, false},
+		{"count=25", 25, false},
+		{"count=50", 50, false},
+		{"count=51", 0, true},
+		{"count=0", 0, true},
+		{"count=-1", 0, true},
+		{"count=abc", 0, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := queryInt(q, "count", 10, 50)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%q: got %d, %v, want %d", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseFeedOptions(t *testing.T) {
+	const source = "https://example.com/rss.xml"
+	tests := []struct {
+		url     string
+		count   int
+		workers int
+		writer  string
+		page    int
+		archive int
+		ruleKey string
+		wantErr bool
+	}{
+		{url: "/feed/" + source, count: 10, workers: 2, writer: "application/xml", page: 1, archive: -1},
+		{url: "/feed/" + url.QueryEscape(source), count: 10, workers: 2, writer: "application/xml", page: 1, archive: -1},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&count=25&workers=1&format=atom", count: 25, workers: 1, writer: "application/atom+xml", page: 1, archive: -1},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&format=json&page=3", count: 10, workers: 2, writer: "application/feed+json", page: 3, archive: -1},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&archive=0", count: 10, workers: 2, writer: "application/xml", page: 1, archive: 0},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&selector=//article&strip=//aside", count: 10, workers: 2, writer: "application/xml", page: 1, archive: -1, ruleKey: "selector=%2F%2Farticle&strip=%2F%2Faside"},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&count=51", wantErr: true},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&workers=3", wantErr: true},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&format=xml", wantErr: true},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&page=0", wantErr: true},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&archive=-1", wantErr: true},
+		{url: "/feed?url=" + url.QueryEscape(source) + "&selector=//[", wantErr: true},
+		{url: "/feed?url=ftp://example.com/rss.xml", wantErr: true},
+		{url: "/feed?url=http://127.0.0.1/rss.xml", wantErr: true},
+		{url: "/feed", wantErr: true},
+	}
+	for _, tt := range tests {
+		opts, err := parseFeedOptions(httptest.NewRequest("GET", tt.url, nil), rss20Writer)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", tt.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.url, err)
+			continue
+		}
+		if opts.source != source || opts.count != tt.count || opts.workers != tt.workers ||
+			opts.writer.contentType != tt.writer || opts.page != tt.page || opts.archive != tt.archive || opts.ruleKey != tt.ruleKey {
+			t.Errorf("%s: got %+v", tt.url, opts)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// hostileFeed returns the feed of testdata/hostile.xml, with the text
+// which must be escaped by the writers.
+func hostileFeed(t *testing.T) *fullFeed {
+	b, err := ioutil.ReadFile("testdata/hostile.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := parseFeed(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Title = "Tom & Jerry <script>alert(1)</script> \"quoted\" 'single'"
+	feed.Description = "ends with ]]> and a \x00null\x01 \x1b[31mescape\x0b\x0c"
+	feed.Categories = []string{"a&b", "<c>", "]]>"}
+	item := feed.Items[0]
+	item.Title = "]]><script>alert(1)</script><![CDATA["
+	item.Summary = "<p>Summary & more</p>]]>\x08\uFFFE"
+	item.Content = "<![CDATA[<p>Content</p>]]><p>after</p>\x07"
+	item.Categories = []string{"R&D", "<b>bold</b>", "x\x02y"}
+	ext := feed.ext(item)
+	ext.fulltext = true
+	feed.Items[1].Content = "<p>Second & last</p>"
+	feed.items[feed.Items[1]].failure = &itemFailure{reason: failureStatus, status: 404, message: "https://example.com/b got status-code is not 200(404) <&>"}
+	return feed
+}
+
+func TestOutputGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		output func(io.Writer, *fullFeed) error
+	}{
+		{"hostile.rss.golden", outputRss20},
+		{"hostile.atom.golden", outputAtom},
+		{"hostile.json.golden", outputJSONFeed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.output(&buf, hostileFeed(t)); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tt.name)
+			if *update {
+				if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("output differs from %s:\n%s", golden, buf.String())
+			}
+			if strings.HasSuffix(tt.name, ".json.golden") {
+				if !json.Valid(buf.Bytes()) {
+					t.Error("output is not valid JSON")
+				}
+				return
+			}
+			// the output must be well-formed, and a parsed feed again.
+			d := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+			for {
+				if _, err := d.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("output is not well-formed: %s", err)
+				}
+			}
+			feed, err := parseFeed(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "Tom & Jerry <script>alert(1)</script> \"quoted\" 'single'"; feed.Title != want {
+				t.Errorf("got title %q, want %q", feed.Title, want)
+			}
+			if want := "]]><script>alert(1)</script><![CDATA["; feed.Items[0].Title != want {
+				t.Errorf("got item title %q, want %q", feed.Items[0].Title, want)
+			}
+			if got := strings.Join(feed.Categories, " "); got != "a&b <c> ]]>" {
+				t.Errorf("got categories %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// nextPageExprs find the link to the next page of an article, when the
+// site rule has no next_page_link.
+var nextPageExprs = []*xpath.Expr{
+	xpath.MustCompile(`//link[@rel='next']/@href`),
+	xpath.MustCompile(`//a[@rel='next']/@href`),
+	xpath.MustCompile(`//*[contains(@class,'pagination') or contains(@class,'pager') or contains(@class,'page-numbers')]` +
+		`//a[contains(@class,'next') or contains(translate(normalize-space(.),'NEXT','next'),'next') or normalize-space(.)='›' or normalize-space(.)='»']/@href`),
+}
+
+// nextPage returns the URL of the next page of htmlDoc, or nil.
+func nextPage(u *url.URL, htmlDoc *html.Node, rule *siteRule) *url.URL {
+	exprs := nextPageExprs
+	if rule != nil && len(rule.nextPage) > 0 {
+		exprs = rule.nextPage
+	}
+	for _, expr := range exprs {
+		n := querySelector(htmlDoc, expr)
+		if n == nil {
+			continue
+		}
+		// the expression may select the <a> element or its href.
+		href := htmlquery.SelectAttr(n, "href")
+		if href == "" {
+			href = htmlquery.InnerText(n)
+		}
+		next, err := u.Parse(strings.TrimSpace(href))
+		if err != nil || next.Host != u.Host || !(next.Scheme == "http" || next.Scheme == "https") {
+			continue
+		}
+		next.Fragment = ""
+		return next
+	}
+	return nil
+}
+
+// extractPages extracts the article of htmlDoc, and of its next pages
+// up to -max-pages, into a single article.
+func extractPages(ctx context.Context, u *url.URL, htmlDoc *html.Node, rule *siteRule) (*article, error) {
+	next := nextPage(u, htmlDoc, rule)
+	a, err := extract(u, htmlDoc, rule)
+	if err != nil {
+		return nil, err
+	}
+	if next == nil || aMaxPages.get() <= 1 {
+		return a, nil
+	}
+	seen := map[string]bool{u.String(): true}
+	blocks := make(map[string]bool)
+	content := dedupeBlocks(a.Content, blocks)
+	for n := 1; next != nil && n < aMaxPages.get() && !seen[next.String()]; n++ {
+		seen[next.String()] = true
+		var page *article
+		page, next, err = extractPage(ctx, next, rule)
+		if err != nil {
+			logrus.Warnf("GET %s failed. %s", next, err)
+			break
+		}
+		content += dedupeBlocks(page.Content, blocks)
+	}
+	a.Content = removePageLinks(content, seen)
+	return a, nil
+}
+
+// extractPage extracts a next page, and returns the page after it.
+func extractPage(ctx context.Context, u *url.URL, rule *siteRule) (*article, *url.URL, error) {
+	resp, err := httpGet(ctx, u.String(), nil, aMaxArticleSize.get()<<20)
+	if err != nil {
+		return nil, u, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, u, &statusError{u.String(), resp.StatusCode}
+	}
+	if err := checkHTML(resp); err != nil {
+		return nil, u, err
+	}
+	htmlDoc, err := htmlquery.Parse(resp.Body)
+	if err != nil {
+		return nil, u, err
+	}
+	next := nextPage(resp.Request.URL, htmlDoc, rule)
+	a, err := extract(resp.Request.URL, htmlDoc, rule)
+	if err != nil {
+		return nil, u, err
+	}
+	return a, next, nil
+}
+
+var blockElements = []string{
+	"p", "h1", "h2", "h3", "h4", "h5", "h6", "li", "blockquote", "figure", "pre", "table", "header", "footer", "nav",
+}
+
+// dedupeBlocks removes the block elements of content which are already
+// in blocks, such as the headline and the boilerplate repeated on every
+// page, and adds the rest to blocks.
+func dedupeBlocks(content string, blocks map[string]bool) string {
+	nodes, err := parseFragment(content)
+	if err != nil {
+		return content
+	}
+	var walk func(n *html.Node) bool
+	walk = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && contains(blockElements, n.Data) {
+			key := strings.Join(strings.Fields(htmlquery.InnerText(n)), " ")
+			if key == "" {
+				key = htmlquery.OutputHTML(n, true)
+			}
+			if blocks[key] {
+				return false
+			}
+			blocks[key] = true
+			return true
+		}
+		for child := n.FirstChild; child != nil; {
+			next := child.NextSibling
+			if !walk(child) {
+				n.RemoveChild(child)
+			}
+			child = next
+		}
+		return true
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		if walk(n) {
+			html.Render(&b, n)
+		}
+	}
+	return b.String()
+}
+
+// removePageLinks removes the links to the pages which are stitched.
+func removePageLinks(content string, pages map[string]bool) string {
+	nodes, err := parseFragment(content)
+	if err != nil {
+		return content
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		if n.Type == html.ElementNode && n.Data == "a" && pages[htmlquery.SelectAttr(n, "href")] {
+			continue
+		}
+		for _, a := range htmlquery.Find(n, "//a") {
+			if a.Parent != nil && pages[htmlquery.SelectAttr(a, "href")] {
+				a.Parent.RemoveChild(a)
+			}
+		}
+		html.Render(&b, n)
+	}
+	return b.String()
+}
+
+// parseFragment parses the HTML fragment of the content of an article.
+func parseFragment(content string) ([]*html.Node, error) {
+	return html.ParseFragment(strings.NewReader(content), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+}
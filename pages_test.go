@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/antchfx/htmlquery"
+)
+
+func TestDedupeBlocks(t *testing.T) {
+	blocks := make(map[string]bool)
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`<h1>Title</h1><p>One</p><p>Two</p>`, `<h1>Title</h1><p>One</p><p>Two</p>`},
+		// the headline and the repeated paragraph of the next page.
+		{`<h1>Title</h1><p>Two</p><p>Three</p>`, `<p>Three</p>`},
+		{`<div><h1> Title </h1><p>Four  five</p></div>`, `<div><p>Four  five</p></div>`},
+		{`<p>four five</p><p>Four five</p>`, `<p>four five</p>`},
+		// the empty blocks are compared by their HTML.
+		{`<figure><img src="a.png"/></figure><figure><img src="a.png"/></figure><figure><img src="b.png"/></figure>`,
+			`<figure><img src="a.png"/></figure><figure><img src="b.png"/></figure>`},
+		{`text<span>inline</span>`, `text<span>inline</span>`},
+	}
+	for _, tt := range tests {
+		if got := dedupeBlocks(tt.content, blocks); got != tt.want {
+			t.Errorf("dedupeBlocks(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRemovePageLinks(t *testing.T) {
+	pages := map[string]bool{"http://example.com/a": true, "http://example.com/a?page=2": true}
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`<a href="http://example.com/a?page=2">2</a><p>text</p>`, `<p>text</p>`},
+		{`<p>text <a href="http://example.com/a">1</a><a href="http://example.com/b">b</a></p>`,
+			`<p>text <a href="http://example.com/b">b</a></p>`},
+	}
+	for _, tt := range tests {
+		if got := removePageLinks(tt.content, pages); got != tt.want {
+			t.Errorf("removePageLinks(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestNextPageConcurrent(t *testing.T) {
+	u, _ := url.Parse("http://example.com/a")
+	// the shared expressions of the next page are evaluated at the same
+	// time, without any lock, for the race detector.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			htmlDoc, _ := htmlquery.Parse(strings.NewReader(`<div class="pagination"><a href="/a/1">1</a><a href="/a/2">Next »</a></div>`))
+			for j := 0; j < 50; j++ {
+				if next := nextPage(u, htmlDoc, nil); next == nil || next.String() != "http://example.com/a/2" {
+					t.Errorf("nextPage = %v", next)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNextPage(t *testing.T) {
+	u, _ := url.Parse("http://example.com/a")
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<p>no pages</p>`, ""},
+		{`<link rel="next" href="/a?page=2">`, "http://example.com/a?page=2"},
+		{`<a rel="next" href="a/2#top">next</a>`, "http://example.com/a/2"},
+		{`<div class="pagination"><a href="/a/1">1</a><a href="/a/2">Next »</a></div>`, "http://example.com/a/2"},
+		{`<ul class="page-numbers"><li><a class="next" href="/a/2">2</a></li></ul>`, "http://example.com/a/2"},
+		{`<div class="pager"><a href="/a/2">»</a></div>`, "http://example.com/a/2"},
+		// the next page must be on the same host.
+		{`<a rel="next" href="http://example.org/a/2">next</a>`, ""},
+		{`<a rel="next" href="javascript:next()">next</a>`, ""},
+	}
+	for _, tt := range tests {
+		htmlDoc, err := htmlquery.Parse(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if next := nextPage(u, htmlDoc, nil); next != nil {
+			got = next.String()
+		}
+		if got != tt.want {
+			t.Errorf("nextPage(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+
+	rule, err := loadRule(writeRule(t, t.TempDir(), "example.com.txt", "next_page_link: //a[@class='more']\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	htmlDoc, _ := htmlquery.Parse(strings.NewReader(`<a rel="next" href="/b">next</a><a class="more" href="/c">more</a>`))
+	if next := nextPage(u, htmlDoc, rule); next == nil || next.String() != "http://example.com/c" {
+		t.Errorf("nextPage with rule = %v", next)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRetryWait is the max time to wait for a retry, a longer Retry-After
+// is not waited.
+const maxRetryWait = time.Minute
+
+// retries is the number of retries of all requests.
+var retries int64
+
+// isRetryable reports whether the request may succeed if it is retried,
+// and how long the server asked to wait.
+func isRetryable(resp *http.Response, err error) (bool, time.Duration) {
+	if err != nil {
+		var forbidden *forbiddenError
+		if errors.As(err, &forbidden) {
+			return false, 0
+		}
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, 0
+		}
+		var (
+			unknownAuthority x509.UnknownAuthorityError
+			invalidCert      x509.CertificateInvalidError
+			hostname         x509.HostnameError
+		)
+		if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) || errors.As(err, &hostname) {
+			return false, 0
+		}
+		// timeout, connection refused or reset.
+		return true, 0
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true, retryAfter(resp.Header.Get("Retry-After"))
+	}
+	return false, 0
+}
+
+// retryAfter parses Retry-After, which is seconds or an HTTP date.
+func retryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// backoff returns the time to wait before the retry attempt(from 0), the
+// exponential backoff with jitter.
+func backoff(attempt int) time.Duration {
+	d := aRetryBackoff.get() << uint(attempt)
+	if d <= 0 || d > maxRetryWait {
+		d = maxRetryWait
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// doWithRetry sends the request built by newRequest, and retries it up to
+// -retries times if it fails temporarily, until ctx is done. The requests
+// to a host which keeps failing are rejected by its circuit breaker.
+func doWithRetry(ctx context.Context, host string, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if attempt == 0 {
+			if err := breakers.allow(host); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil && ctx.Err() != nil {
+			// cancelled by the caller, it is not a failure of the host.
+			breakers.abort(host)
+			return nil, err
+		}
+		retryable, wait := isRetryable(resp, err)
+		if retryable && attempt < aRetries.get() && wait <= maxRetryWait {
+			if wait <= 0 {
+				wait = backoff(attempt)
+			}
+			if resp != nil {
+				io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+				resp.Body.Close()
+			}
+			atomic.AddInt64(&retries, 1)
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				breakers.abort(host)
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		// only the temporary errors are the failures of the host, such as
+		// 404 is not. The permanent errors without a response, such as a
+		// host which does not exist or is forbidden, are neither.
+		if err != nil && !retryable {
+			breakers.abort(host)
+		} else {
+			breakers.done(host, retryable)
+		}
+		return resp, err
+	}
+}
+
+// breakers are the circuit breakers of hosts.
+var breakers = &breakerSet{hosts: make(map[string]*breaker)}
+
+// breakerSet opens the circuit of a host after -breaker-threshold failures
+// in a row, the requests to it are rejected for -breaker-cooldown. Then a
+// request is let through, the circuit is closed if it succeeds.
+type breakerSet struct {
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (s *breakerSet) allow(host string) error {
+	if aBreakerThreshold.get() <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.hosts[host]
+	if !ok || b.failures < aBreakerThreshold.get() {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return fmt.Errorf("%s failed %d times, circuit is open until %s", host, b.failures, b.openUntil.Format(time.RFC3339))
+	}
+	b.probing = true
+	return nil
+}
+
+func (s *breakerSet) done(host string, failed bool) {
+	if aBreakerThreshold.get() <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !failed {
+		delete(s.hosts, host)
+		return
+	}
+	b, ok := s.hosts[host]
+	if !ok {
+		b = new(breaker)
+		s.hosts[host] = b
+	}
+	b.failures++
+	b.probing = false
+	if b.failures >= aBreakerThreshold.get() {
+		b.openUntil = time.Now().Add(aBreakerCooldown.get())
+	}
+}
+
+// abort ends the request to host which is cancelled, it is neither a
+// failure nor a success.
+func (s *breakerSet) abort(host string) {
+	if aBreakerThreshold.get() <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.hosts[host]; ok {
+		b.probing = false
+	}
+}
+
+// open returns the number of hosts whose circuit is open.
+func (s *breakerSet) open() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	now := time.Now()
+	for _, b := range s.hosts {
+		if b.failures >= aBreakerThreshold.get() && now.Before(b.openUntil) {
+			n++
+		}
+	}
+	return n
+}
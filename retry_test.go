@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header string
+		err    error
+		want   bool
+		wait   time.Duration
+	}{
+		{name: "200", status: 200},
+		{name: "404", status: 404},
+		{name: "429", status: 429, want: true},
+		{name: "503 with Retry-After", status: 503, header: "7", want: true, wait: 7 * time.Second},
+		{name: "500", status: 500, want: true},
+		{name: "501", status: 501},
+		{name: "reset", err: errors.New("connection reset by peer"), want: true},
+		{name: "forbidden", err: forbiddenf("127.0.0.1 is an internal address")},
+		{name: "wrapped forbidden", err: &net.OpError{Op: "dial", Err: forbiddenf("denied")}},
+		{name: "nxdomain", err: &net.DNSError{Name: "nx.example.com", IsNotFound: true}},
+		{name: "dns timeout", err: &net.DNSError{Name: "example.com", IsTimeout: true}, want: true},
+		{name: "unknown authority", err: x509.UnknownAuthorityError{}},
+		{name: "hostname", err: x509.HostnameError{Host: "example.com"}},
+	}
+	for _, tt := range tests {
+		var resp *http.Response
+		if tt.err == nil {
+			resp = &http.Response{StatusCode: tt.status, Header: make(http.Header)}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+		}
+		got, wait := isRetryable(resp, tt.err)
+		if got != tt.want || wait != tt.wait {
+			t.Errorf("%s: got %v, %s, want %v, %s", tt.name, got, wait, tt.want, tt.wait)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d := retryAfter(""); d != 0 {
+		t.Errorf("empty: got %s", d)
+	}
+	if d := retryAfter("120"); d != 2*time.Minute {
+		t.Errorf("seconds: got %s", d)
+	}
+	if d := retryAfter("-1"); d != 0 {
+		t.Errorf("negative: got %s", d)
+	}
+	if d := retryAfter("soon"); d != 0 {
+		t.Errorf("invalid: got %s", d)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d := retryAfter(date); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("date: got %s", d)
+	}
+}
+
+// setFlag sets the option name to value until the test ends.
+func setFlag(t *testing.T, name, value string) {
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+func TestBreakerSet(t *testing.T) {
+	setFlag(t, "breaker-threshold", "3")
+	setFlag(t, "breaker-cooldown", "1h")
+	s := &breakerSet{hosts: make(map[string]*breaker)}
+	for i := 0; i < 2; i++ {
+		s.done("a", true)
+	}
+	// a cancelled request does not reset the failures.
+	s.abort("a")
+	if err := s.allow("a"); err != nil {
+		t.Fatalf("open after 2 failures: %s", err)
+	}
+	s.done("a", true)
+	if err := s.allow("a"); err == nil {
+		t.Fatal("closed after 3 failures")
+	}
+	if err := s.allow("b"); err != nil {
+		t.Fatalf("another host is rejected: %s", err)
+	}
+	if n := s.open(); n != 1 {
+		t.Fatalf("got %d open, want 1", n)
+	}
+
+	// after the cooldown, a request is let through as the probe.
+	s.hosts["a"].openUntil = time.Now()
+	if err := s.allow("a"); err != nil {
+		t.Fatalf("the probe is rejected: %s", err)
+	}
+	if err := s.allow("a"); err == nil {
+		t.Fatal("a second probe is let through")
+	}
+	s.done("a", false)
+	if err := s.allow("a"); err != nil || len(s.hosts) != 0 {
+		t.Fatalf("the success does not close the circuit: %v", err)
+	}
+
+	setFlag(t, "breaker-threshold", "0")
+	for i := 0; i < 5; i++ {
+		s.done("c", true)
+	}
+	if err := s.allow("c"); err != nil || len(s.hosts) != 0 {
+		t.Fatal("the disabled breaker is used")
+	}
+}
+
+// useTestClient configures the client for the test servers on the
+// loopback address.
+func useTestClient(t *testing.T) *hostTransport {
+	guard.update("", "", true)
+	ht, err := newHostTransport(new(clientConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients.set(ht)
+	t.Cleanup(func() { guard.update("", "", false) })
+	return ht
+}
+
+func TestDoWithRetry(t *testing.T) {
+	ht := useTestClient(t)
+	setFlag(t, "retries", "2")
+	setFlag(t, "retry-backoff", "1ms")
+	setFlag(t, "breaker-threshold", "2")
+	setFlag(t, "breaker-cooldown", "1h")
+	old := breakers
+	t.Cleanup(func() { breakers = old })
+	breakers = &breakerSet{hosts: make(map[string]*breaker)}
+
+	var n int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	get := func(ctx context.Context, rawurl string) (*http.Response, error) {
+		return doWithRetry(ctx, "host", func(ctx context.Context) (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", rawurl, nil)
+		})
+	}
+	resp, err := get(context.Background(), ts.URL)
+	if err != nil || resp.StatusCode != 200 || n != 3 {
+		t.Fatalf("got %v, %v after %d requests", resp, err, n)
+	}
+	resp.Body.Close()
+
+	// the failures in a row open the circuit.
+	atomic.StoreInt32(&n, -100)
+	for i := 0; i < 2; i++ {
+		resp, err := get(context.Background(), ts.URL)
+		if err != nil || resp.StatusCode != 503 {
+			t.Fatalf("got %v, %v", resp, err)
+		}
+		resp.Body.Close()
+		if i == 0 {
+			// a permanent error is not a success, the failures are kept.
+			guard.update("", "", false)
+			ht.closeIdleConnections()
+			if _, err := get(context.Background(), ts.URL); err == nil {
+				t.Fatal("the internal address is fetched")
+			}
+			guard.update("", "", true)
+		}
+	}
+	if _, err := get(context.Background(), ts.URL); err == nil || breakers.open() != 1 {
+		t.Fatalf("the circuit is not open: %v", err)
+	}
+
+	// a cancelled wait of retry returns at once.
+	breakers = &breakerSet{hosts: make(map[string]*breaker)}
+	setFlag(t, "retry-backoff", "1h")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := get(ctx, ts.URL); err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+		t.Fatalf("got %v after %s", err, time.Since(start))
+	}
+	if len(breakers.hosts) != 0 {
+		t.Fatal("the cancelled request is a failure")
+	}
+}
@@ -1,67 +1,121 @@
 package main
 
 import (
-	"html"
 	"io"
-
-	"github.com/zhengchun/syndfeed"
+	"net/http"
+	"strconv"
 )
 
-func outputRss20(sw io.StringWriter, feed *syndfeed.Feed) {
-	sw.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
-	sw.WriteString(`<?xml-stylesheet type="text/xsl" href="/assets/rss2full.xsl"?>`)
-	sw.WriteString(`<rss xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/" version="2.0">`)
+// rss20Namespaces are the namespaces declared by the RSS 2.0 writer.
+var rss20Namespaces = []string{
+	"content", "http://purl.org/rss/1.0/modules/content/",
+	"dc", "http://purl.org/dc/elements/1.1/",
+	"atom", "http://www.w3.org/2005/Atom",
+	"media", "http://search.yahoo.com/mrss/",
+}
+
+func outputRss20(w io.Writer, feed *fullFeed) error {
+	x := newXMLWriter(w)
+	x.procInst("xml", `version="1.0" encoding="UTF-8"`)
+	x.procInst("xml-stylesheet", `type="text/xsl" href="/assets/rss2full.xsl"`)
+	var attrs, declared []string
+	for i := 0; i < len(rss20Namespaces); i += 2 {
+		attrs = append(attrs, "xmlns:"+rss20Namespaces[i], rss20Namespaces[i+1])
+		declared = append(declared, rss20Namespaces[i])
+	}
+	if feed.archive {
+		attrs = append(attrs, "xmlns:fh", historyNamespace)
+		declared = append(declared, "fh")
+	}
+	if feed.failed() {
+		attrs = append(attrs, "xmlns:rss2full", statusNamespace)
+		declared = append(declared, "rss2full")
+	}
+	attrs = append(attrs, feed.namespaces(declared...)...)
+	x.start("rss", append(attrs, "version", "2.0")...)
 	// channel
-	sw.WriteString(`<channel>`)
-	// title
-	sw.WriteString(`<title><![CDATA[` + feed.Title + `]]></title>`)
+	x.start("channel")
+	x.element("title", feed.Title)
 	// link
 	if len(feed.Links) > 0 {
-		sw.WriteString(`<link>` + feed.Links[0].URL + `</link>`)
-	}
-	if feed.Language != "" {
-		sw.WriteString("<language>" + feed.Language + "</language>")
+		x.element("link", feed.Links[0].URL)
 	}
-	if feed.Description != "" {
-		sw.WriteString(`<description>` + feed.Description + "</description>")
+	x.elementIf("language", feed.Language)
+	x.elementIf("description", feed.Description)
+	x.elementIf("copyright", feed.Copyright)
+	for _, v := range feed.Categories {
+		x.element("category", v)
 	}
 	if !feed.LastUpdatedTime.IsZero() {
-		sw.WriteString("<lastBuildDate>" + feed.LastUpdatedTime.Format("Mon, 02 Jan 2006 15:04:05 GMT") + "</lastBuildDate>")
+		x.element("lastBuildDate", feed.LastUpdatedTime.UTC().Format(http.TimeFormat))
 	}
-	sw.WriteString(`<generator>full-rss(https://github.com/feedocean/full-rss)</generator>`)
+	x.element("generator", "full-rss(https://github.com/feedocean/full-rss)")
 	// image
 	if feed.ImageURL != "" {
-		sw.WriteString("<image>")
-		sw.WriteString(`<url>` + feed.ImageURL + `</url>`)
-		sw.WriteString("</image>")
+		x.start("image")
+		x.element("url", feed.ImageURL)
+		x.end("image")
+	}
+	for _, v := range feed.archiveLinks {
+		x.element("atom:link", "", "rel", v.RelType, "href", v.URL)
+	}
+	if feed.archive {
+		x.element("fh:archive", "")
+	}
+	for _, v := range feed.elements {
+		x.node(v)
 	}
-	for i := 0; i < len(feed.Items); i++ {
-		item := feed.Items[i]
-		sw.WriteString("<item>")
-		// title
-		sw.WriteString(`<title><![CDATA[` + item.Title + `]]></title>`)
+	for _, item := range feed.Items {
+		ext := feed.ext(item)
+		x.start("item")
+		x.element("title", item.Title)
 		// link
-		sw.WriteString("<link>" + html.EscapeString(item.Links[0].URL) + "</link>")
-		// description
-		if item.Summary != "" {
-			sw.WriteString(`<description><![CDATA[` + item.Summary + `]]></description>`)
+		var link string
+		if len(item.Links) > 0 {
+			link = item.Links[0].URL
+			x.element("link", link)
+		}
+		// guid
+		if item.Id != "" {
+			isPermaLink := ext.isPermaLink
+			if isPermaLink == "" {
+				isPermaLink = strconv.FormatBool(item.Id == link)
+			}
+			x.element("guid", item.Id, "isPermaLink", isPermaLink)
 		}
+		// description
+		x.elementIf("description", item.Summary)
 		// authors
 		for _, v := range item.Authors {
-			sw.WriteString(`<dc:creator><![CDATA[` + v.Name + `]]></dc:creator>`)
-		}
-		if item.Content != "" {
-			sw.WriteString(`<content:encoded><![CDATA[` + item.Content + `]]></content:encoded>`)
+			x.element("dc:creator", v.Name)
 		}
+		x.elementIf("content:encoded", item.Content)
 		for _, v := range item.Categories {
-			sw.WriteString(`<category>` + v + `</category>`)
+			x.element("category", v)
 		}
 		// pubDate
 		if !item.PublishDate.IsZero() {
-			sw.WriteString("<pubDate>" + item.PublishDate.Format("Mon, 02 Jan 2006 15:04:05 GMT") + "</pubDate>")
+			x.element("pubDate", item.PublishDate.UTC().Format(http.TimeFormat))
+		}
+		if !item.LastUpdatedTime.IsZero() {
+			x.element("atom:updated", atomDate(item.LastUpdatedTime))
+		}
+		x.elementIf("dc:rights", item.Copyright)
+		for _, v := range feed.enclosures(item) {
+			x.element("enclosure", "", "url", v.URL, "length", v.Length, "type", v.Type)
+		}
+		for _, v := range ext.elements {
+			x.node(v)
+		}
+		if v := ext.thumbnail(); v != "" {
+			x.element("media:thumbnail", "", "url", v)
+		}
+		if f := ext.failure; f != nil {
+			x.element("rss2full:failure", f.message, f.attrs()...)
 		}
-		sw.WriteString("</item>")
+		x.end("item")
 	}
-	sw.WriteString(`</channel>`)
-	sw.WriteString(`</rss>`)
+	x.end("channel")
+	x.end("rss")
+	return x.flush()
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/judwhite/go-svc/svc"
 	"github.com/julienschmidt/httprouter"
@@ -23,8 +27,47 @@ var (
 	aVersl             = flag.Bool("version", false, "Show version")
 	aHelp              = flag.Bool("h", false, "Show help")
 	aHelpl             = flag.Bool("help", false, "Show help")
-	aItemCount         = flag.Int("item-count", 10, "Define number of items in feed")
-	aConnectionPerFeed = flag.Int("connection-per-feed", 2, "Define number of parallel connections per feed")
+	aItemCount         = newIntFlag("item-count", 10, "Define number of items in feed")
+	aMaxItemCount      = newIntFlag("max-item-count", 50, "Define max number of items which a request may ask for")
+	aConnectionPerFeed = newIntFlag("connection-per-feed", 2, "Define number of parallel connections per feed")
+	aCacheDir          = flag.String("cache-dir", "", "Directory to persist extracted articles")
+	aCacheTTL          = flag.Duration("cache-ttl", 24*time.Hour, "Define how long an extracted article is cached")
+	aCacheSize         = flag.Int("cache-size", 1000, "Define max number of cached articles")
+	aMaxAge            = newDurationFlag("max-age", 15*time.Minute, "Define how long clients may cache a feed")
+	aRulesDir          = flag.String("rules-dir", "", "Directory of site-specific extraction rules")
+	aMaxPages          = newIntFlag("max-pages", 5, "Define max number of pages of a multi-page article")
+	aImageProxy        = flag.Bool("image-proxy", false, "Serve the images of articles through rss2full")
+	aImageKey          = flag.String("image-key", "", "Secret key to sign the URLs of image proxy")
+	aImageCacheDir     = flag.String("image-cache-dir", "", "Directory to cache the proxied images")
+	aImageCacheSize    = flag.Int64("image-cache-size", 256, "Define max size(MB) of cached images")
+	aImageMaxWidth     = newIntFlag("image-max-width", 0, "Define max width of proxied images, 0 to keep the size")
+	aBaseURL           = newStringFlag("base-url", "", "Public URL of rss2full, such as https://example.com")
+	aRefreshInterval   = flag.Duration("refresh-interval", 0, "Define how often the requested feeds are refreshed in background, 0 to disable")
+	aSubscriptionIdle  = flag.Duration("subscription-idle", 7*24*time.Hour, "Define how long a subscribed feed is kept without requests")
+	aSubscriptionsFile = flag.String("subscriptions-file", "", "File to persist the subscribed feeds")
+	aArchiveDir        = flag.String("archive-dir", "", "Directory to archive the items of feeds")
+	aArchiveSize       = flag.Int("archive-size", 500, "Define max number of archived items per feed")
+	aArchiveRetention  = flag.Duration("archive-retention", 0, "Define how long an item is archived, 0 to keep it")
+	aMaxConnections    = flag.Int("max-connections", 32, "Define max number of parallel connections of all feeds")
+	aConnectionPerHost = flag.Int("connection-per-host", 2, "Define max number of parallel connections per host")
+	aHostRate          = flag.Float64("host-rate", 0, "Define max requests per second per host, 0 for unlimited")
+	aFeedTimeout       = newDurationFlag("feed-timeout", 30*time.Second, "Define how long to wait for the articles, the feed is partial after it")
+	aRetries           = newIntFlag("retries", 2, "Define max number of retries of a failed request")
+	aRetryBackoff      = newDurationFlag("retry-backoff", time.Second, "Define the wait before the first retry, doubled for each retry")
+	aBreakerThreshold  = newIntFlag("breaker-threshold", 5, "Define number of failures in a row to stop requesting a host, 0 to disable")
+	aBreakerCooldown   = newDurationFlag("breaker-cooldown", time.Minute, "Define how long to stop requesting a failing host")
+	aAllowPrivate      = flag.Bool("allow-private", false, "Allow to fetch the private, loopback and link-local addresses")
+	aAllowHosts        = flag.String("allow-hosts", "", "Comma-separated hosts or CIDRs which may be fetched, all if empty")
+	aDenyHosts         = flag.String("deny-hosts", "", "Comma-separated hosts or CIDRs which may not be fetched")
+	aMaxFeedSize       = newInt64Flag("max-feed-size", 10, "Define max size(MB) of a source feed")
+	aMaxArticleSize    = newInt64Flag("max-article-size", 5, "Define max size(MB) of an article page")
+	aMaxImageSize      = newInt64Flag("max-image-size", 10, "Define max size(MB) of a proxied image")
+	aClientConfig      = flag.String("client-config", "", "JSON file of User-Agent, headers, cookies, proxy and TLS options of hosts")
+	aMinTextLength     = newIntFlag("min-text-length", 100, "Define min number of characters of an extracted article")
+	aFailureNotice     = newBoolFlag("failure-notice", false, "Prepend a notice to the content of the items whose full text failed")
+	aConfig            = flag.String("config", "", "JSON config file of the options, the client and the feeds")
+	aDrainTimeout      = newDurationFlag("drain-timeout", 30*time.Second, "Define how long to wait for the requests in progress on shutdown")
+	aShutdownDelay     = newDurationFlag("shutdown-delay", 0, "Define how long to serve as not ready before draining on shutdown")
 )
 
 const usage = `rss2full %s
@@ -35,16 +78,58 @@ Usage:
   rss2full -v | -version
 
 Options:
-  -a <addr>                   Bind address [default: *]
-  -p <port>                   Bind port [default: 8088]
-  -h, -help                   Show help
-  -v, -version                Show version
-  -item-count <num>           Define number of items in feed
-  -connection-per-feed <num>  Define number of parallel connections(workers) per feed
+  -a <addr>                      Bind address [default: *]
+  -p <port>                      Bind port [default: 8088]
+  -h, -help                      Show help
+  -v, -version                   Show version
+  -config <file>                 JSON config file of the options, the client and the feeds, see README
+  -item-count <num>              Define number of items in feed
+  -max-item-count <num>          Define max number of items which a request may ask for [default: 50]
+  -connection-per-feed <num>     Define number of parallel connections(workers) per feed
+  -cache-dir <dir>               Directory to persist extracted articles [default: memory only]
+  -cache-ttl <duration>          Define how long an extracted article is cached [default: 24h]
+  -cache-size <num>              Define max number of cached articles, 0 to disable [default: 1000]
+  -max-age <duration>            Define how long clients may cache a feed [default: 15m]
+  -rules-dir <dir>               Directory of site-specific extraction rules
+  -max-pages <num>               Define max number of pages of a multi-page article [default: 5]
+  -image-proxy                   Serve the images of articles through rss2full
+  -image-key <key>               Secret key to sign the URLs of image proxy [default: random]
+  -image-cache-dir <dir>         Directory to cache the proxied images
+  -image-cache-size <MB>         Define max size of cached images [default: 256]
+  -image-max-width <px>          Define max width of proxied images, 0 to keep the size [default: 0]
+  -base-url <url>                Public URL of rss2full [default: the requested host]
+  -refresh-interval <duration>   Refresh the requested feeds in background, 0 to disable [default: 0]
+  -subscription-idle <duration>  Define how long a subscribed feed is kept without requests [default: 168h]
+  -subscriptions-file <file>     File to persist the subscribed feeds [default: memory only]
+  -archive-dir <dir>             Directory to archive the items of feeds, and serve the history
+  -archive-size <num>            Define max number of archived items per feed [default: 500]
+  -archive-retention <duration>  Define how long an item is archived, 0 to keep it [default: 0]
+  -max-connections <num>         Define max number of parallel connections of all feeds [default: 32]
+  -connection-per-host <num>     Define max number of parallel connections per host [default: 2]
+  -host-rate <num>               Define max requests per second per host, 0 for unlimited [default: 0]
+  -feed-timeout <duration>       Define how long to wait for the articles, 0 to wait all [default: 30s]
+  -retries <num>                 Define max number of retries of a failed request [default: 2]
+  -retry-backoff <duration>      Define the wait before the first retry, doubled for each retry [default: 1s]
+  -breaker-threshold <num>       Define number of failures in a row to stop requesting a host, 0 to disable [default: 5]
+  -breaker-cooldown <duration>   Define how long to stop requesting a failing host [default: 1m]
+  -allow-private                 Allow to fetch the private, loopback and link-local addresses
+  -allow-hosts <list>            Comma-separated hosts or CIDRs which may be fetched [default: all]
+  -deny-hosts <list>             Comma-separated hosts or CIDRs which may not be fetched
+  -max-feed-size <MB>            Define max size of a source feed, 0 for unlimited [default: 10]
+  -max-article-size <MB>         Define max size of an article page, 0 for unlimited [default: 5]
+  -max-image-size <MB>           Define max size of a proxied image, 0 for unlimited [default: 10]
+  -client-config <file>          JSON file of User-Agent, headers, cookies, proxy and TLS options of hosts
+  -min-text-length <num>         Define min number of characters of an extracted article [default: 100]
+  -failure-notice                Prepend a notice to the content of the items whose full text failed
+  -drain-timeout <duration>      Define how long to wait for the requests in progress on shutdown [default: 30s]
+  -shutdown-delay <duration>     Define how long to serve as not ready before draining on shutdown [default: 0]
 `
 
 type program struct {
-	quit chan struct{}
+	quit   chan struct{}
+	server *http.Server
+	// wg is the background goroutines, which are waited on exit.
+	wg sync.WaitGroup
 }
 
 func (p *program) Init(env svc.Environment) error {
@@ -64,6 +149,69 @@ func (p *program) Start() error {
 		showVersion()
 	}
 
+	conf, err := initConfig()
+	if err != nil {
+		return err
+	}
+
+	articles = newArticleCache(*aCacheDir, *aCacheTTL, *aCacheSize)
+	if err := articles.Load(); err != nil {
+		return err
+	}
+
+	m, err := loadRules(*aRulesDir)
+	if err != nil {
+		return err
+	}
+	setRules(m)
+
+	if *aImageProxy {
+		initImageKey(*aImageKey)
+		images = newImageCache(*aImageCacheDir, *aImageCacheSize<<20)
+		if err := images.Load(); err != nil {
+			return err
+		}
+	}
+
+	guard.update(*aAllowHosts, *aDenyHosts, *aAllowPrivate)
+	cc, err := conf.clientConfig()
+	if err != nil {
+		return err
+	}
+	transport, err := newHostTransport(cc)
+	if err != nil {
+		return err
+	}
+	clients.set(transport)
+	scheduler = newFetchScheduler(*aMaxConnections, *aConnectionPerHost, *aHostRate)
+
+	if *aArchiveDir != "" {
+		archives = newArchiveStore(*aArchiveDir, *aArchiveSize, *aArchiveRetention)
+		if err := archives.Load(); err != nil {
+			return err
+		}
+	}
+
+	if *aRefreshInterval > 0 {
+		subscriptions = newSubscriptionRegistry(*aSubscriptionsFile, *aRefreshInterval, *aSubscriptionIdle)
+		if err := subscriptions.Load(); err != nil {
+			return err
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			subscriptions.Run(p.quit)
+		}()
+	}
+
+	if *aConfig != "" {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			watchConfig(p.quit)
+		}()
+	}
+
 	port := getPort(*aPort)
 	addr := *aAddr + ":" + strconv.Itoa(port)
 	listener, err := net.Listen("tcp", addr)
@@ -71,29 +219,61 @@ func (p *program) Start() error {
 		return err
 	}
 
-	go func() {
-		wwwroot := http.Dir("./wwwroot")
-		fs := http.FileServer(wwwroot)
+	wwwroot := http.Dir("./wwwroot")
+	fs := http.FileServer(wwwroot)
 
-		router := httprouter.New()
-		router.GET("/feed/*feed", FullRss)
-		router.Handler("GET", "/assets/*filepath", fs)
-		router.Handler("GET", "/", fs)
+	router := httprouter.New()
+	for _, method := range []string{"GET", "HEAD"} {
+		router.Handle(method, "/feed/*feed", FullRss)
+		router.Handle(method, "/feed", FullRss)
+		router.Handle(method, "/atom/*feed", FullAtom)
+		router.Handle(method, "/atom", FullAtom)
+		router.Handle(method, "/json/*feed", FullJSON)
+		router.Handle(method, "/json", FullJSON)
+	}
+	if *aImageProxy {
+		router.GET("/img", ImageProxy)
+	}
+	router.GET("/metrics", Metrics)
+	router.GET("/ready", Ready)
+	router.Handler("GET", "/assets/*filepath", fs)
+	router.Handler("GET", "/", fs)
 
-		if err := http.Serve(listener, router); err != nil {
+	p.server = &http.Server{Handler: router}
+	go func() {
+		if err := p.server.Serve(listener); err != http.ErrServerClosed {
 			logrus.Fatalf("http.Serve got error: %v", err)
 		}
-		<-p.quit
-		listener.Close()
-		logrus.Info("exit")
 	}()
+	atomic.StoreInt32(&ready, 1)
 	logrus.Infof("version: %s", Version)
 	logrus.Infof("listen on %s \n", addr)
 	return nil
 }
 
+// Stop reports not ready, waits -shutdown-delay for the load balancer to
+// notice it, and then stops accepting requests and waits the requests in
+// progress up to -drain-timeout. The subscriptions are saved at last, the
+// caches and archives are written when they are modified.
 func (p *program) Stop() error {
+	atomic.StoreInt32(&ready, 0)
+	logrus.Info("shutting down")
+	if aShutdownDelay.get() > 0 {
+		time.Sleep(aShutdownDelay.get())
+	}
+	ctx := context.Background()
+	if aDrainTimeout.get() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, aDrainTimeout.get())
+		defer cancel()
+	}
+	if err := p.server.Shutdown(ctx); err != nil {
+		logrus.Warnf("the requests are not done in %s, close them. %s", aDrainTimeout.get(), err)
+		p.server.Close()
+	}
 	close(p.quit)
+	p.wg.Wait()
+	logrus.Info("exit")
 	return nil
 }
 
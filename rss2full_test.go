@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	m.Run()
+}
+
+// startTestProgram serves /ready and /slow, which responds after release
+// is closed or its request is gone.
+func startTestProgram(t *testing.T) (p *program, url string, started, release chan struct{}) {
+	started, release = make(chan struct{}, 1), make(chan struct{})
+	router := httprouter.New()
+	router.GET("/ready", Ready)
+	router.GET("/slow", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		started <- struct{}{}
+		select {
+		case <-release:
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = &program{quit: make(chan struct{}), server: &http.Server{Handler: router}}
+	go p.server.Serve(listener)
+	atomic.StoreInt32(&ready, 1)
+	t.Cleanup(func() {
+		atomic.StoreInt32(&ready, 0)
+		p.server.Close()
+	})
+	return p, "http://" + listener.Addr().String(), started, release
+}
+
+func getStatus(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestStopDrain(t *testing.T) {
+	setFlag(t, "shutdown-delay", "200ms")
+	setFlag(t, "drain-timeout", "5s")
+	p, url, started, release := startTestProgram(t)
+	if code, _ := getStatus(t, url+"/ready"); code != 200 {
+		t.Fatalf("/ready got %d before Stop", code)
+	}
+
+	type response struct {
+		code int
+		body string
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			responses <- response{0, err.Error()}
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		responses <- response{resp.StatusCode, string(b)}
+	}()
+	<-started
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	// not ready at once, the requests are still served in the delay.
+	for atomic.LoadInt32(&ready) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if code, _ := getStatus(t, url+"/ready"); code != 503 {
+		t.Errorf("/ready got %d after Stop begins", code)
+	}
+	select {
+	case <-p.quit:
+		t.Fatal("quit is closed before the requests are done")
+	case <-time.After(300 * time.Millisecond):
+	}
+	close(release)
+	if r := <-responses; r.code != 200 || r.body != "done" {
+		t.Errorf("the request in progress got %d %q", r.code, r.body)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop does not return")
+	}
+	select {
+	case <-p.quit:
+	default:
+		t.Error("quit is not closed")
+	}
+}
+
+func TestStopDrainTimeout(t *testing.T) {
+	setFlag(t, "shutdown-delay", "0s")
+	setFlag(t, "drain-timeout", "200ms")
+	p, url, started, _ := startTestProgram(t)
+	go http.Get(url + "/slow")
+	<-started
+	start := time.Now()
+	p.Stop()
+	// the request which is not done in -drain-timeout is closed.
+	if d := time.Since(start); d < 200*time.Millisecond || d > 2*time.Second {
+		t.Errorf("Stop returned after %s", d)
+	}
+	select {
+	case <-p.quit:
+	default:
+		t.Error("quit is not closed")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/antchfx/xpath"
+	"github.com/sirupsen/logrus"
+)
+
+// rules is the site-specific extraction rules, by host, which are
+// replaced when the config is reloaded.
+var (
+	rulesMu sync.RWMutex
+	rules   = make(map[string]*siteRule)
+)
+
+func setRules(m map[string]*siteRule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules = m
+}
+
+// siteRule is a site-specific extraction rule, loaded from a file of
+// the rules directory, similar to the FiveFilters site config:
+//
+//	# comment
+//	title: //h1
+//	body: //article
+//	strip: //aside
+//	strip_id_or_class: comments
+//	author: //a[@rel='author']
+//	date: //time/@datetime
+//	next_page_link: //a[@rel='next']
+//
+// The file is named by the host, such as example.com.txt, and a file
+// named with a leading dot, such as .example.com.txt, matches all of
+// its subdomains too. Every key except title, author and date may be
+// repeated, the first body expression which matches wins.
+type siteRule struct {
+	title    []*xpath.Expr
+	body     []*xpath.Expr
+	strip    []*xpath.Expr
+	author   []*xpath.Expr
+	date     []*xpath.Expr
+	nextPage []*xpath.Expr
+}
+
+// loadRules loads all rule files from dir.
+func loadRules(dir string) (map[string]*siteRule, error) {
+	m := make(map[string]*siteRule)
+	if dir == "" {
+		return m, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".txt") {
+			continue
+		}
+		name := filepath.Join(dir, fi.Name())
+		rule, err := loadRule(name)
+		if err != nil {
+			return nil, err
+		}
+		m[strings.ToLower(strings.TrimSuffix(fi.Name(), ".txt"))] = rule
+	}
+	logrus.Infof("rules: loaded %d site rules from %s", len(m), dir)
+	return m, nil
+}
+
+func loadRule(name string) (*siteRule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rule := new(siteRule)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: missing ':'", name, n)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if key == "strip_id_or_class" {
+			key = "strip"
+			value = fmt.Sprintf("//*[contains(@class,'%[1]s') or contains(@id,'%[1]s')]", strings.Trim(value, `"'`))
+		}
+		var list *[]*xpath.Expr
+		switch key {
+		case "title":
+			list = &rule.title
+		case "body":
+			list = &rule.body
+		case "strip":
+			list = &rule.strip
+		case "author":
+			list = &rule.author
+		case "date":
+			list = &rule.date
+		case "next_page_link":
+			list = &rule.nextPage
+		default:
+			// the other directives of FiveFilters are not supported.
+			logrus.Debugf("%s:%d: unknown directive %s", name, n, key)
+			continue
+		}
+		expr, err := xpath.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, n, err)
+		}
+		*list = append(*list, expr)
+	}
+	return rule, scanner.Err()
+}
+
+// lookupRule returns the rule of host, or nil if there is no rule.
+func lookupRule(host string) *siteRule {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	host = strings.ToLower(host)
+	if rule, ok := rules[host]; ok {
+		return rule
+	}
+	if rule, ok := rules[strings.TrimPrefix(host, "www.")]; ok {
+		return rule
+	}
+	// .example.com matches example.com and all of its subdomains.
+	for {
+		if rule, ok := rules["."+host]; ok {
+			return rule
+		}
+		i := strings.Index(host, ".")
+		if i < 0 {
+			return nil
+		}
+		host = host[i+1:]
+	}
+}
+
+// merge returns a copy of r overridden by other, the body of other
+// replaces and the strip of other is added. r may be nil.
+func (r *siteRule) merge(other *siteRule) *siteRule {
+	rule := new(siteRule)
+	if r != nil {
+		*rule = *r
+	}
+	if len(other.body) > 0 {
+		rule.body = other.body
+	}
+	rule.strip = append(rule.strip[:len(rule.strip):len(rule.strip)], other.strip...)
+	return rule
+}
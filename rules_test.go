@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRule(t *testing.T, dir, name, s string) string {
+	t.Helper()
+	name = filepath.Join(dir, name)
+	if err := ioutil.WriteFile(name, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadRule(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		s       string
+		counts  [6]int // title, body, strip, author, date, next_page_link
+		wantErr string
+	}{
+		{"", [6]int{}, ""},
+		{"# comment\n\ntitle: //h1\nbody: //article\nbody: //main\n", [6]int{1, 2, 0, 0, 0, 0}, ""},
+		{"strip: //aside\nstrip_id_or_class: 'comments'\nauthor: //a[@rel='author']\ndate: //time/@datetime\nnext_page_link: //a[@rel='next']\n", [6]int{0, 0, 2, 1, 1, 1}, ""},
+		{"prune: no\ntidy: no\n", [6]int{}, ""},
+		{"title //h1\n", [6]int{}, ":1: missing ':'"},
+		{"\nbody: //article[\n", [6]int{}, ":2: "},
+	}
+	for _, tt := range tests {
+		rule, err := loadRule(writeRule(t, dir, "example.com.txt", tt.s))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadRule(%q) error = %v, want %q", tt.s, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadRule(%q) error = %v", tt.s, err)
+			continue
+		}
+		got := [6]int{len(rule.title), len(rule.body), len(rule.strip), len(rule.author), len(rule.date), len(rule.nextPage)}
+		if got != tt.counts {
+			t.Errorf("loadRule(%q) = %v, want %v", tt.s, got, tt.counts)
+		}
+	}
+}
+
+func TestLookupRule(t *testing.T) {
+	dir := t.TempDir()
+	writeRule(t, dir, "example.com.txt", "body: //article\n")
+	writeRule(t, dir, ".example.org.txt", "body: //main\n")
+	writeRule(t, dir, "readme.md", "")
+	m, err := loadRules(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 {
+		t.Fatalf("loaded %d rules, want 2", len(m))
+	}
+	setRules(m)
+	t.Cleanup(func() { setRules(make(map[string]*siteRule)) })
+
+	tests := []struct {
+		host string
+		want *siteRule
+	}{
+		{"example.com", m["example.com"]},
+		{"EXAMPLE.com", m["example.com"]},
+		{"www.example.com", m["example.com"]},
+		{"blog.example.com", nil},
+		{"example.org", m[".example.org"]},
+		{"www.example.org", m[".example.org"]},
+		{"a.b.example.org", m[".example.org"]},
+		{"example.net", nil},
+		{"org", nil},
+	}
+	for _, tt := range tests {
+		if got := lookupRule(tt.host); got != tt.want {
+			t.Errorf("lookupRule(%q) = %p, want %p", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestSiteRuleMerge(t *testing.T) {
+	dir := t.TempDir()
+	rule, err := loadRule(writeRule(t, dir, "a.txt", "title: //h1\nbody: //article\nstrip: //aside\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := loadRule(writeRule(t, dir, "b.txt", "body: //main\nstrip: //nav\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rule.merge(other)
+	if len(got.title) != 1 || len(got.body) != 1 || got.body[0] != other.body[0] || len(got.strip) != 2 {
+		t.Errorf("merge = %+v", got)
+	}
+	if len(rule.strip) != 1 || rule.body[0] == other.body[0] {
+		t.Error("merge changes the rule")
+	}
+	got = (*siteRule)(nil).merge(other)
+	if len(got.body) != 1 || len(got.strip) != 1 {
+		t.Errorf("nil merge = %+v", got)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// scheduler is the process-wide scheduler of the article fetches.
+var scheduler = newFetchScheduler(32, 2, 0)
+
+// fetchScheduler runs the fetches of all feed requests, at most max at
+// the same time and perHost to a host, with at least interval between the
+// fetches to a host. The feed requests take turns, so a large feed does
+// not hold up the others.
+type fetchScheduler struct {
+	max      int
+	perHost  int
+	interval time.Duration
+
+	mu      sync.Mutex
+	running int
+	hosts   map[string]*hostState
+	queues  []*fetchQueue
+	next    int
+	timer   *time.Timer
+	timerAt time.Time
+}
+
+type hostState struct {
+	running int
+	last    time.Time
+}
+
+// fetchQueue is the fetches of a feed request, at most limit of them run
+// at the same time.
+type fetchQueue struct {
+	s       *fetchScheduler
+	limit   int
+	running int
+	jobs    []*fetchJob
+}
+
+type fetchJob struct {
+	host string
+	fn   func()
+}
+
+// newFetchScheduler returns a scheduler, rate is the max requests per
+// second to a host, 0 for unlimited.
+func newFetchScheduler(max, perHost int, rate float64) *fetchScheduler {
+	if max < 1 {
+		max = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+	s := &fetchScheduler{
+		max:     max,
+		perHost: perHost,
+		hosts:   make(map[string]*hostState),
+	}
+	if rate > 0 {
+		s.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return s
+}
+
+func (s *fetchScheduler) newQueue(limit int) *fetchQueue {
+	q := &fetchQueue{s: s, limit: limit}
+	s.mu.Lock()
+	s.queues = append(s.queues, q)
+	s.mu.Unlock()
+	return q
+}
+
+// add adds the fetch fn to host to the queue.
+func (q *fetchQueue) add(host string, fn func()) {
+	q.s.mu.Lock()
+	defer q.s.mu.Unlock()
+	q.jobs = append(q.jobs, &fetchJob{host: host, fn: fn})
+	q.s.dispatch()
+}
+
+// close drops the fetches which are not started yet, the running ones
+// are finished.
+func (q *fetchQueue) close() {
+	s := q.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q.jobs = nil
+	for i, v := range s.queues {
+		if v == q {
+			s.queues = append(s.queues[:i], s.queues[i+1:]...)
+			break
+		}
+	}
+	s.dispatch()
+}
+
+func (s *fetchScheduler) host(name string) *hostState {
+	h, ok := s.hosts[name]
+	if !ok {
+		h = new(hostState)
+		s.hosts[name] = h
+	}
+	return h
+}
+
+// dispatch starts the fetches as many as the limits allow, one of each
+// queue in turn. s.mu must be held.
+func (s *fetchScheduler) dispatch() {
+	now := time.Now()
+	var wait time.Duration
+	for s.running < s.max && len(s.queues) > 0 {
+		var started bool
+		n := len(s.queues)
+		for i := 0; i < n && s.running < s.max; i++ {
+			q := s.queues[s.next%n]
+			s.next = (s.next + 1) % n
+			if q.running >= q.limit {
+				continue
+			}
+			for j, job := range q.jobs {
+				h := s.host(job.host)
+				if h.running >= s.perHost {
+					continue
+				}
+				if d := h.last.Add(s.interval).Sub(now); d > 0 {
+					if wait == 0 || d < wait {
+						wait = d
+					}
+					continue
+				}
+				q.jobs = append(q.jobs[:j], q.jobs[j+1:]...)
+				s.start(q, h, job, now)
+				started = true
+				break
+			}
+		}
+		if !started {
+			break
+		}
+	}
+	// a host is over its rate, dispatch again when it is not.
+	if wait > 0 {
+		at := now.Add(wait)
+		if s.timer == nil || at.Before(s.timerAt) {
+			if s.timer != nil {
+				s.timer.Stop()
+			}
+			s.timerAt = at
+			s.timer = time.AfterFunc(wait, func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.timer = nil
+				s.dispatch()
+			})
+		}
+	}
+}
+
+func (s *fetchScheduler) start(q *fetchQueue, h *hostState, job *fetchJob, now time.Time) {
+	s.running++
+	q.running++
+	h.running++
+	h.last = now
+	go func() {
+		job.fn()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running--
+		q.running--
+		h.running--
+		if h.running == 0 && time.Since(h.last) >= s.interval {
+			delete(s.hosts, job.host)
+		}
+		s.dispatch()
+	}()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testJobs are the fetches of a test, which report their start and run
+// until they are released.
+type testJobs struct {
+	started chan string
+	release map[string]chan struct{}
+}
+
+func newTestJobs(names ...string) *testJobs {
+	j := &testJobs{started: make(chan string, len(names)), release: make(map[string]chan struct{})}
+	for _, name := range names {
+		j.release[name] = make(chan struct{})
+	}
+	return j
+}
+
+func (j *testJobs) fn(name string) func() {
+	return func() {
+		j.started <- name
+		<-j.release[name]
+	}
+}
+
+// wait returns the name of the next started job.
+func (j *testJobs) wait(t *testing.T) string {
+	t.Helper()
+	select {
+	case name := <-j.started:
+		return name
+	case <-time.After(5 * time.Second):
+		t.Fatal("no job is started")
+		return ""
+	}
+}
+
+func (j *testJobs) none(t *testing.T) {
+	t.Helper()
+	select {
+	case name := <-j.started:
+		t.Fatalf("%s is started", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	tests := []struct {
+		max, perHost, limit int
+		hosts               []string
+		want                int
+	}{
+		{max: 3, perHost: 2, limit: 10, hosts: []string{"a", "a", "a", "b"}, want: 3},
+		{max: 10, perHost: 2, limit: 10, hosts: []string{"a", "a", "a", "a"}, want: 2},
+		{max: 10, perHost: 10, limit: 1, hosts: []string{"a", "b", "c"}, want: 1},
+		{max: 0, perHost: 0, limit: 10, hosts: []string{"a", "b"}, want: 1},
+	}
+	for _, tt := range tests {
+		s := newFetchScheduler(tt.max, tt.perHost, 0)
+		q := s.newQueue(tt.limit)
+		var names []string
+		for i := range tt.hosts {
+			names = append(names, string(rune('0'+i)))
+		}
+		jobs := newTestJobs(names...)
+		for i, host := range tt.hosts {
+			q.add(host, jobs.fn(names[i]))
+		}
+		for i := 0; i < tt.want; i++ {
+			jobs.wait(t)
+		}
+		jobs.none(t)
+		for _, name := range names {
+			close(jobs.release[name])
+		}
+		for i := tt.want; i < len(names); i++ {
+			jobs.wait(t)
+		}
+		q.close()
+	}
+}
+
+func TestSchedulerTurns(t *testing.T) {
+	s := newFetchScheduler(2, 10, 0)
+	jobs := newTestJobs("a1", "a2", "a3", "a4", "b1", "b2")
+	qa := s.newQueue(10)
+	for _, name := range []string{"a1", "a2", "a3", "a4"} {
+		qa.add("a", jobs.fn(name))
+	}
+	qb := s.newQueue(10)
+	for _, name := range []string{"b1", "b2"} {
+		qb.add("b", jobs.fn(name))
+	}
+	if a, b := jobs.wait(t), jobs.wait(t); a+b != "a1a2" && a+b != "a2a1" {
+		t.Fatalf("started %s, %s", a, b)
+	}
+	// the queues take turns as the fetches finish.
+	want := []string{"a3", "b1", "a4", "b2"}
+	release := []string{"a1", "a2", "a3", "b1"}
+	for i, name := range release {
+		close(jobs.release[name])
+		if got := jobs.wait(t); got != want[i] {
+			t.Fatalf("started %s after %s, want %s", got, name, want[i])
+		}
+	}
+	close(jobs.release["a4"])
+	close(jobs.release["b2"])
+	qa.close()
+	qb.close()
+}
+
+func TestSchedulerClose(t *testing.T) {
+	s := newFetchScheduler(1, 1, 0)
+	jobs := newTestJobs("a1", "a2", "b1")
+	qa := s.newQueue(10)
+	qa.add("a", jobs.fn("a1"))
+	qa.add("a", jobs.fn("a2"))
+	qb := s.newQueue(10)
+	qb.add("b", jobs.fn("b1"))
+	jobs.wait(t)
+	// a2 is dropped, the running a1 is finished.
+	qa.close()
+	close(jobs.release["a1"])
+	if got := jobs.wait(t); got != "b1" {
+		t.Fatalf("started %s, want b1", got)
+	}
+	close(jobs.release["b1"])
+	qb.close()
+	jobs.none(t)
+}
+
+func TestSchedulerRate(t *testing.T) {
+	s := newFetchScheduler(10, 10, 20)
+	if s.interval != 50*time.Millisecond {
+		t.Fatalf("interval = %s", s.interval)
+	}
+	jobs := newTestJobs("a1", "a2", "a3", "b1")
+	for _, ch := range jobs.release {
+		close(ch)
+	}
+	q := s.newQueue(10)
+	start := time.Now()
+	for _, name := range []string{"a1", "a2", "a3"} {
+		q.add("a", jobs.fn(name))
+	}
+	q.add("b", jobs.fn("b1"))
+	var elapsed []time.Duration
+	for i := 0; i < 4; i++ {
+		if jobs.wait(t) != "b1" {
+			elapsed = append(elapsed, time.Since(start))
+		}
+	}
+	q.close()
+	for i, d := range elapsed {
+		if min := time.Duration(i) * s.interval; d < min {
+			t.Errorf("fetch %d of host a started after %s, want at least %s", i+1, d, min)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sources remembers the last good response of each source feed.
+var sources = &sourceCache{entries: make(map[string]*sourceEntry)}
+
+// maxSources is the number of source feeds remembered, the least recently
+// used ones are forgotten.
+const maxSources = 256
+
+type sourceCache struct {
+	mu      sync.Mutex
+	entries map[string]*sourceEntry
+}
+
+type sourceEntry struct {
+	etag         string
+	lastModified string
+	feed         *fullFeed
+	used         time.Time
+}
+
+func (c *sourceCache) get(source string) *sourceEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[source]
+	if e != nil {
+		e.used = time.Now()
+	}
+	return e
+}
+
+func (c *sourceCache) set(source string, e *sourceEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.used = time.Now()
+	c.entries[source] = e
+	for len(c.entries) > maxSources {
+		var oldest string
+		for k, v := range c.entries {
+			if oldest == "" || v.used.Before(c.entries[oldest].used) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+// loadFeed loads the source feed with a conditional GET. The last good
+// feed is reused if the source is not modified, and is served instead
+// if the source fails(stale-if-error).
+func loadFeed(ctx context.Context, source string) (*fullFeed, error) {
+	last := sources.get(source)
+	v, err := sourceFlight.Do(ctx, source, func(ctx context.Context) (interface{}, error) {
+		return fetchFeed(ctx, source, last)
+	})
+	feed, _ := v.(*fullFeed)
+	if err != nil {
+		if last == nil || ctx.Err() != nil {
+			return nil, err
+		}
+		logrus.Warnf("GET %s failed, serve the last good feed. %s", source, err)
+		feed = last.feed
+	}
+	// the caller will modify the feed, so never returns the cached one.
+	return feed.clone(), nil
+}
+
+func fetchFeed(ctx context.Context, source string, last *sourceEntry) (*fullFeed, error) {
+	header := make(http.Header)
+	if last != nil {
+		if last.etag != "" {
+			header.Set("If-None-Match", last.etag)
+		}
+		if last.lastModified != "" {
+			header.Set("If-Modified-Since", last.lastModified)
+		}
+	}
+	resp, err := httpGet(ctx, source, header, aMaxFeedSize.get()<<20)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if last != nil {
+			return last.feed, nil
+		}
+		fallthrough
+	default:
+		return nil, fmt.Errorf("%s got status-code is not 200(%d)", source, resp.StatusCode)
+	}
+	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediatype {
+	case "text/xml",
+		"application/xml",
+		"application/rss+xml",
+		"application/atom+xml":
+	default:
+		return nil, fmt.Errorf("%s got mediatype is not supported(%s)", source, mediatype)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	feed, err := parseFeed(b)
+	if err != nil {
+		return nil, err
+	}
+	sources.set(source, &sourceEntry{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		feed:         feed,
+	})
+	return feed, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSourceCacheEvict(t *testing.T) {
+	c := &sourceCache{entries: make(map[string]*sourceEntry)}
+	for i := 0; i < maxSources; i++ {
+		c.set(strconv.Itoa(i), &sourceEntry{etag: strconv.Itoa(i)})
+	}
+	// the first source is used again, so the second is the oldest.
+	if e := c.get("0"); e == nil || e.etag != "0" {
+		t.Fatalf("got %v", e)
+	}
+	c.set("new", &sourceEntry{})
+	if len(c.entries) != maxSources {
+		t.Fatalf("got %d entries, want %d", len(c.entries), maxSources)
+	}
+	if c.get("1") != nil {
+		t.Error("the least recently used source is kept")
+	}
+	if c.get("0") == nil || c.get("new") == nil {
+		t.Error("the recently used source is evicted")
+	}
+}
+
+func TestLoadFeed(t *testing.T) {
+	useTestClient(t)
+	setFlag(t, "retries", "0")
+	const rss = `<?xml version="1.0"?><rss version="2.0"><channel><title>t</title>` +
+		`<item><title>a</title><link>http://example.com/a</link></item></channel></rss>`
+	var status, conditional int32 = 200, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&conditional, 1)
+			if atomic.LoadInt32(&status) == 200 {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		if code := atomic.LoadInt32(&status); code != 200 {
+			w.WriteHeader(int(code))
+			return
+		}
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(rss))
+	}))
+	defer ts.Close()
+	source := ts.URL + "/feed.xml"
+	t.Cleanup(func() {
+		sources.mu.Lock()
+		delete(sources.entries, source)
+		sources.mu.Unlock()
+	})
+
+	for i, code := range []int32{200, 200, 500} {
+		atomic.StoreInt32(&status, code)
+		feed, err := loadFeed(context.Background(), source)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(feed.Items) != 1 || feed.Items[0].Title != "a" {
+			t.Fatalf("%d: got %d items", i, len(feed.Items))
+		}
+		// the caller gets a copy of the cached feed.
+		feed.Items[0].Title = "modified"
+	}
+	if conditional != 2 {
+		t.Errorf("got %d conditional requests, want 2", conditional)
+	}
+
+	if _, err := loadFeed(context.Background(), ts.URL+"/other.xml"); err == nil {
+		t.Error("got a feed of the failed source which was never loaded")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// statusNamespace is the namespace of the extension elements of rss2full.
+const statusNamespace = "https://github.com/feedocean/rss2full"
+
+// The reasons of the failures of the items.
+const (
+	failureFetch      = "fetch"
+	failureStatus     = "http-status"
+	failureExtraction = "extraction"
+	failureTimeout    = "timeout"
+)
+
+// itemFailure is why the full text of an item failed, the item keeps the
+// content of the source feed.
+type itemFailure struct {
+	reason string
+	// status is the HTTP status code of the article, if reason is
+	// failureStatus.
+	status  int
+	message string
+}
+
+// statusError is the error of an article which responds not 200.
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s got status-code is not 200(%d)", e.url, e.code)
+}
+
+// extractError is the error of an article page which has no content, or
+// too little text.
+type extractError struct {
+	msg string
+}
+
+func (e *extractError) Error() string {
+	return e.msg
+}
+
+// newFailure returns the failure of err.
+func newFailure(err error) *itemFailure {
+	f := &itemFailure{reason: failureFetch, message: err.Error()}
+	var (
+		status  *statusError
+		extract *extractError
+		netErr  net.Error
+	)
+	switch {
+	case errors.As(err, &status):
+		f.reason, f.status = failureStatus, status.code
+	case errors.As(err, &extract):
+		f.reason = failureExtraction
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		f.reason = failureTimeout
+	}
+	return f
+}
+
+// notice returns the HTML notice of the failure, which is prepended to
+// the content of the item.
+func (f *itemFailure) notice() string {
+	var why string
+	switch f.reason {
+	case failureStatus:
+		why = "the article page responded HTTP " + strconv.Itoa(f.status)
+	case failureExtraction:
+		why = "no article was found in the page"
+	case failureTimeout:
+		why = "the article page timed out"
+	default:
+		why = "the article page could not be fetched"
+	}
+	return `<p class="rss2full-failure"><em>Full text is not available: ` + html.EscapeString(why) + `.</em></p>`
+}
+
+// addFailureNotices prepends the notice to the content of the failed items.
+func addFailureNotices(feed *fullFeed) {
+	for _, item := range feed.Items {
+		f := feed.ext(item).failure
+		if f == nil {
+			continue
+		}
+		content := item.Content
+		if content == "" {
+			content = item.Summary
+		}
+		item.Content = f.notice() + content
+	}
+}
+
+// failed reports whether any item of the feed failed.
+func (f *fullFeed) failed() bool {
+	for _, item := range f.Items {
+		if f.ext(item).failure != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// failures returns the summary of the failed items for the
+// X-Rss2full-Failures header, such as "2; http-status=1, timeout=1".
+func (f *fullFeed) failures() string {
+	count := make(map[string]int)
+	var n int
+	for _, item := range f.Items {
+		if v := f.ext(item).failure; v != nil {
+			count[v.reason]++
+			n++
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	var reasons []string
+	for k, v := range count {
+		reasons = append(reasons, k+"="+strconv.Itoa(v))
+	}
+	sort.Strings(reasons)
+	return strconv.Itoa(n) + "; " + strings.Join(reasons, ", ")
+}
+
+// attrs returns the attributes of the <rss2full:failure> element.
+func (f *itemFailure) attrs() []string {
+	attrs := []string{"reason", f.reason}
+	if f.status != 0 {
+		attrs = append(attrs, "status", strconv.Itoa(f.status))
+	}
+	return attrs
+}
+
+// textLength returns the number of characters of the text of content.
+func textLength(content string) int {
+	var n int
+	z := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		switch z.Next() {
+		case xhtml.ErrorToken:
+			return n
+		case xhtml.TextToken:
+			n += utf8.RuneCount(bytes.TrimSpace(z.Text()))
+		}
+	}
+}
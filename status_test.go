@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zhengchun/syndfeed"
+)
+
+func TestNewFailure(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason string
+		status int
+	}{
+		{errors.New("connection refused"), failureFetch, 0},
+		{&statusError{"http://example.com/a", 404}, failureStatus, 404},
+		{fmt.Errorf("page 2: %w", &statusError{"http://example.com/a?page=2", 503}), failureStatus, 503},
+		{&extractError{"no content was extracted"}, failureExtraction, 0},
+		{context.DeadlineExceeded, failureTimeout, 0},
+		{fmt.Errorf("GET: %w", context.DeadlineExceeded), failureTimeout, 0},
+		{&net.OpError{Op: "dial", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, failureTimeout, 0},
+		{context.Canceled, failureFetch, 0},
+	}
+	for _, tt := range tests {
+		f := newFailure(tt.err)
+		if f.reason != tt.reason || f.status != tt.status || f.message != tt.err.Error() {
+			t.Errorf("newFailure(%v) = %+v, want %s %d", tt.err, f, tt.reason, tt.status)
+		}
+	}
+}
+
+func TestFailures(t *testing.T) {
+	feed := &fullFeed{Feed: new(syndfeed.Feed), items: make(map[*syndfeed.Item]*itemExtension)}
+	add := func(f *itemFailure) {
+		item := &syndfeed.Item{Summary: "summary"}
+		feed.Items = append(feed.Items, item)
+		feed.items[item] = &itemExtension{failure: f}
+	}
+	add(nil)
+	if feed.failed() || feed.failures() != "" {
+		t.Errorf("got %q", feed.failures())
+	}
+	add(&itemFailure{reason: failureTimeout})
+	add(&itemFailure{reason: failureStatus, status: 404})
+	add(&itemFailure{reason: failureStatus, status: 500})
+	if want := "3; http-status=2, timeout=1"; !feed.failed() || feed.failures() != want {
+		t.Errorf("got %q, want %q", feed.failures(), want)
+	}
+
+	addFailureNotices(feed)
+	if feed.Items[0].Content != "" {
+		t.Errorf("notice added to %q", feed.Items[0].Content)
+	}
+	for _, item := range feed.Items[1:] {
+		if !strings.HasPrefix(item.Content, `<p class="rss2full-failure">`) || !strings.HasSuffix(item.Content, "</p>summary") {
+			t.Errorf("got %q", item.Content)
+		}
+	}
+	if !strings.Contains(feed.Items[2].Content, "HTTP 404") {
+		t.Errorf("got %q", feed.Items[2].Content)
+	}
+}
+
+func TestTextLength(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int
+	}{
+		{"", 0},
+		{"plain text", 10},
+		{"<p>  one  </p>\n<p>two</p>", 6},
+		{`<img src="a.png"/><a href="b">link</a>`, 4},
+		{"<p>日本語</p>", 3},
+		{"<p>&amp;</p>", 1},
+	}
+	for _, tt := range tests {
+		if got := textLength(tt.content); got != tt.want {
+			t.Errorf("textLength(%q) = %d, want %d", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestArticleFailure(t *testing.T) {
+	useTestClient(t)
+	setFlag(t, "retries", "0")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+		case "/500":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("<h1>Internal Server Error</h1>"))
+		case "/empty":
+			w.Header().Set("Content-Type", "text/html")
+		case "/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.4"))
+		}
+	}))
+	defer ts.Close()
+	tests := []struct {
+		path   string
+		reason string
+		status int
+	}{
+		{"/404", failureStatus, 404},
+		{"/500", failureStatus, 500},
+		{"/empty", failureExtraction, 0},
+		{"/pdf", failureExtraction, 0},
+	}
+	for _, tt := range tests {
+		link := ts.URL + tt.path
+		_, err := extractArticle(context.Background(), link, link, new(feedOptions))
+		if err == nil {
+			t.Errorf("%s: no error", tt.path)
+			continue
+		}
+		if f := newFailure(err); f.reason != tt.reason || f.status != tt.status {
+			t.Errorf("%s: got %s %d (%s), want %s %d", tt.path, f.reason, f.status, f.message, tt.reason, tt.status)
+		}
+	}
+}
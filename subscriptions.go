@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// subscriptions is the registry of the requested feeds, it is used only
+// if -refresh-interval is set.
+var subscriptions *subscriptionRegistry
+
+// subscriptionRegistry remembers every requested feed and refreshes it in
+// background, so the requests are served from the pre-built feed at once.
+// A feed which is not requested for idle is removed.
+type subscriptionRegistry struct {
+	file     string
+	interval time.Duration
+	idle     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*subscription
+	dirty   bool
+	// refreshes are the refreshes in progress.
+	refreshes sync.WaitGroup
+}
+
+// subscription is a requested feed with the options which build it.
+type subscription struct {
+	Source     string    `json:"source"`
+	Count      int       `json:"count"`
+	Rule       string    `json:"rule,omitempty"`
+	LastAccess time.Time `json:"last_access"`
+
+	opts       *feedOptions
+	feed       *fullFeed
+	next       time.Time
+	refreshing bool
+}
+
+func newSubscriptionRegistry(file string, interval, idle time.Duration) *subscriptionRegistry {
+	return &subscriptionRegistry{
+		file:     file,
+		interval: interval,
+		idle:     idle,
+		entries:  make(map[string]*subscription),
+	}
+}
+
+func subscriptionKey(source string, count int, rule string) string {
+	return source + "\n" + strconv.Itoa(count) + "\n" + rule
+}
+
+// newSubscriptionOptions returns the options to refresh a subscription,
+// the default workers of the feed are used for the background refresh.
+func newSubscriptionOptions(source string, count int, rule string) (*feedOptions, error) {
+	if err := validateSource(source); err != nil {
+		return nil, err
+	}
+	q, err := url.ParseQuery(rule)
+	if err != nil {
+		return nil, err
+	}
+	_, workers, _, _ := feedDefaults(source)
+	opts := &feedOptions{source: source, count: count, workers: workers}
+	if opts.rule, opts.ruleKey, err = overrideRule(q); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// jitter returns d ±10%, so the feeds subscribed at the same time are
+// not refreshed at the same time.
+func jitter(d time.Duration) time.Duration {
+	return d - d/10 + time.Duration(rand.Int63n(int64(d/5)+1))
+}
+
+// intervalOf returns the refresh interval of feed, the <ttl> of the
+// feed is respected if it is longer than the default.
+func (r *subscriptionRegistry) intervalOf(feed *fullFeed) time.Duration {
+	if feed.ttl > r.interval {
+		return feed.ttl
+	}
+	return r.interval
+}
+
+// Load loads the subscriptions from file, they are refreshed within an
+// interval after start.
+func (r *subscriptionRegistry) Load() error {
+	if r.file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(r.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var list []*subscription
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range list {
+		if now.Sub(s.LastAccess) > r.idle {
+			continue
+		}
+		opts, err := newSubscriptionOptions(s.Source, s.Count, s.Rule)
+		if err != nil {
+			logrus.Warnf("subscription: %s is invalid. %s", s.Source, err)
+			continue
+		}
+		s.opts = opts
+		s.next = now.Add(time.Duration(rand.Int63n(int64(r.interval))))
+		r.entries[subscriptionKey(s.Source, s.Count, s.Rule)] = s
+	}
+	logrus.Infof("subscription: loaded %d feeds from %s", len(r.entries), r.file)
+	return nil
+}
+
+// Save writes the subscriptions to file if they are changed.
+func (r *subscriptionRegistry) Save() {
+	r.mu.Lock()
+	if r.file == "" || !r.dirty {
+		r.mu.Unlock()
+		return
+	}
+	list := make([]*subscription, 0, len(r.entries))
+	for _, s := range r.entries {
+		list = append(list, s)
+	}
+	b, err := json.Marshal(list)
+	r.dirty = false
+	r.mu.Unlock()
+	if err != nil {
+		return
+	}
+	// write a temporary file first, a crash never leaves a broken file.
+	tmp := r.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		logrus.Warnf("subscription: write %s failed. %s", r.file, err)
+		return
+	}
+	if err := os.Rename(tmp, r.file); err != nil {
+		logrus.Warnf("subscription: write %s failed. %s", r.file, err)
+	}
+}
+
+// Get returns the pre-built feed of opts. A feed requested first time is
+// built now and subscribed.
+func (r *subscriptionRegistry) Get(ctx context.Context, opts *feedOptions) (*fullFeed, error) {
+	key := subscriptionKey(opts.source, opts.count, opts.ruleKey)
+	now := time.Now()
+	r.mu.Lock()
+	s, ok := r.entries[key]
+	if ok {
+		s.LastAccess = now
+		r.dirty = true
+		if s.feed != nil {
+			feed := s.feed
+			r.mu.Unlock()
+			return feed.clone(), nil
+		}
+	}
+	r.mu.Unlock()
+
+	feed, err := buildFeed(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	subOpts, err := newSubscriptionOptions(opts.source, opts.count, opts.ruleKey)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok = r.entries[key]; !ok {
+		if err != nil {
+			// such as the host is denied by a reload, it is served but not
+			// subscribed.
+			logrus.Warnf("subscription: %s is invalid. %s", opts.source, err)
+			return feed.clone(), nil
+		}
+		s = &subscription{
+			Source:     opts.source,
+			Count:      opts.count,
+			Rule:       opts.ruleKey,
+			LastAccess: now,
+			opts:       subOpts,
+		}
+		r.entries[key] = s
+		r.dirty = true
+		logrus.Infof("subscription: %s subscribed", opts.source)
+	}
+	s.feed = feed
+	s.next = now.Add(jitter(r.intervalOf(feed)))
+	return feed.clone(), nil
+}
+
+// Run refreshes the subscriptions when they are due, until quit is closed.
+// The refreshes in progress are cancelled then, and the subscriptions are
+// saved after they return.
+func (r *subscriptionRegistry) Run(quit <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tick := time.Minute
+	if r.interval < tick {
+		tick = r.interval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.refreshDue(ctx, now)
+			r.Save()
+		case <-quit:
+			cancel()
+			r.refreshes.Wait()
+			r.Save()
+			return
+		}
+	}
+}
+
+// refreshDue removes the idle subscriptions, and refreshes the due ones.
+func (r *subscriptionRegistry) refreshDue(ctx context.Context, now time.Time) {
+	var due []*subscription
+	r.mu.Lock()
+	for key, s := range r.entries {
+		if now.Sub(s.LastAccess) > r.idle {
+			delete(r.entries, key)
+			r.dirty = true
+			logrus.Infof("subscription: %s expired", s.Source)
+			continue
+		}
+		if !s.refreshing && !now.Before(s.next) {
+			s.refreshing = true
+			due = append(due, s)
+		}
+	}
+	r.mu.Unlock()
+	for _, s := range due {
+		r.refreshes.Add(1)
+		go func(s *subscription) {
+			defer r.refreshes.Done()
+			r.refresh(ctx, s)
+		}(s)
+	}
+}
+
+// refresh builds the feed of s again, the last feed is kept if it fails.
+func (r *subscriptionRegistry) refresh(ctx context.Context, s *subscription) {
+	feed, err := buildFeed(ctx, s.opts)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.refreshing = false
+	if err != nil {
+		logrus.Warnf("subscription: refresh %s failed. %s", s.Source, err)
+		s.next = time.Now().Add(jitter(r.interval))
+		return
+	}
+	logrus.Debugf("subscription: %s refreshed", s.Source)
+	s.feed = feed
+	s.next = time.Now().Add(jitter(r.intervalOf(feed)))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testSource serves a feed whose item is new on every request, or the
+// status if it is not 200.
+type testSource struct {
+	*httptest.Server
+	requests int32
+	status   int32
+}
+
+func newTestSource(t *testing.T) *testSource {
+	useTestClient(t)
+	setFlag(t, "retries", "0")
+	s := &testSource{status: 200}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := atomic.LoadInt32(&s.status); code != 200 {
+			w.WriteHeader(int(code))
+			return
+		}
+		n := atomic.AddInt32(&s.requests, 1)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, `<rss version="2.0"><channel><title>%s</title><item><guid>%d</guid><title>item %[2]d</title></item></channel></rss>`, r.URL.Path, n)
+	}))
+	t.Cleanup(func() {
+		s.Close()
+		sources.mu.Lock()
+		for source := range sources.entries {
+			delete(sources.entries, source)
+		}
+		sources.mu.Unlock()
+	})
+	return s
+}
+
+func getSubscription(t *testing.T, r *subscriptionRegistry, source string) string {
+	t.Helper()
+	opts, err := newSubscriptionOptions(source, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := r.Get(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 1 {
+		t.Fatalf("got %d items", len(feed.Items))
+	}
+	return feed.Items[0].Title
+}
+
+func TestSubscriptionGet(t *testing.T) {
+	ts := newTestSource(t)
+	r := newSubscriptionRegistry("", time.Hour, 24*time.Hour)
+	if got := getSubscription(t, r, ts.URL+"/feed"); got != "item 1" {
+		t.Errorf("got %q", got)
+	}
+	if len(r.entries) != 1 || !r.dirty {
+		t.Fatalf("got %d subscriptions, dirty %v", len(r.entries), r.dirty)
+	}
+	// the pre-built feed is served, and the caller gets a copy of it.
+	opts, _ := newSubscriptionOptions(ts.URL+"/feed", 10, "")
+	feed, err := r.Get(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Items[0].Title = "modified"
+	if got := getSubscription(t, r, ts.URL+"/feed"); got != "item 1" || atomic.LoadInt32(&ts.requests) != 1 {
+		t.Errorf("got %q after %d requests", got, atomic.LoadInt32(&ts.requests))
+	}
+	// another count is another subscription.
+	opts, _ = newSubscriptionOptions(ts.URL+"/feed", 5, "")
+	if _, err := r.Get(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.entries) != 2 {
+		t.Errorf("got %d subscriptions", len(r.entries))
+	}
+
+	// a feed which fails is not subscribed.
+	atomic.StoreInt32(&ts.status, 500)
+	opts, _ = newSubscriptionOptions(ts.URL+"/other", 10, "")
+	if _, err := r.Get(context.Background(), opts); err == nil {
+		t.Error("got the feed of a failed source")
+	}
+	if len(r.entries) != 2 {
+		t.Errorf("got %d subscriptions", len(r.entries))
+	}
+}
+
+func TestSubscriptionRefreshDue(t *testing.T) {
+	ts := newTestSource(t)
+	r := newSubscriptionRegistry("", time.Hour, 24*time.Hour)
+	getSubscription(t, r, ts.URL+"/feed")
+	now := time.Now()
+
+	refreshDue := func(at time.Time) {
+		r.refreshDue(context.Background(), at)
+		r.refreshes.Wait()
+	}
+	refreshDue(now.Add(time.Minute))
+	if atomic.LoadInt32(&ts.requests) != 1 {
+		t.Errorf("refreshed before the interval, %d requests", atomic.LoadInt32(&ts.requests))
+	}
+	refreshDue(now.Add(2 * time.Hour))
+	if got := getSubscription(t, r, ts.URL+"/feed"); got != "item 2" {
+		t.Errorf("got %q after refresh", got)
+	}
+
+	// the last good feed is kept if the refresh fails.
+	atomic.StoreInt32(&ts.status, 500)
+	sources.mu.Lock()
+	delete(sources.entries, ts.URL+"/feed")
+	sources.mu.Unlock()
+	refreshDue(now.Add(4 * time.Hour))
+	if got := getSubscription(t, r, ts.URL+"/feed"); got != "item 2" {
+		t.Errorf("got %q after a failed refresh", got)
+	}
+	for _, s := range r.entries {
+		if s.refreshing || !s.next.After(now) {
+			t.Errorf("refreshing %v, next %s", s.refreshing, s.next)
+		}
+	}
+
+	// the feed which is not requested for idle is removed.
+	r.dirty = false
+	refreshDue(time.Now().Add(25 * time.Hour))
+	if len(r.entries) != 0 || !r.dirty {
+		t.Errorf("got %d subscriptions, dirty %v", len(r.entries), r.dirty)
+	}
+}
+
+func TestSubscriptionSaveLoad(t *testing.T) {
+	ts := newTestSource(t)
+	file := filepath.Join(t.TempDir(), "subscriptions.json")
+	r := newSubscriptionRegistry(file, time.Hour, 24*time.Hour)
+	getSubscription(t, r, ts.URL+"/a")
+	getSubscription(t, r, ts.URL+"/b")
+	r.Save()
+	if r.dirty {
+		t.Error("dirty after save")
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file is kept, %v", err)
+	}
+
+	loaded := newSubscriptionRegistry(file, time.Hour, 24*time.Hour)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.entries) != 2 {
+		t.Fatalf("loaded %d subscriptions, want 2", len(loaded.entries))
+	}
+	now := time.Now()
+	for key, s := range loaded.entries {
+		if s.opts == nil || s.feed != nil || s.next.After(now.Add(time.Hour)) {
+			t.Errorf("%s: opts %v, feed %v, next %s", key, s.opts, s.feed, s.next)
+		}
+		if key != subscriptionKey(s.Source, 10, "") {
+			t.Errorf("got key %q", key)
+		}
+	}
+
+	// the idle and the invalid subscriptions are not loaded.
+	list := []*subscription{
+		{Source: ts.URL + "/a", Count: 10, LastAccess: now},
+		{Source: ts.URL + "/idle", Count: 10, LastAccess: now.Add(-25 * time.Hour)},
+		{Source: "ftp://example.com/feed", Count: 10, LastAccess: now},
+		{Source: ts.URL + "/rule", Count: 10, Rule: "selector=//div[", LastAccess: now},
+	}
+	b, _ := json.Marshal(list)
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded = newSubscriptionRegistry(file, time.Hour, 24*time.Hour)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.entries) != 1 || loaded.entries[subscriptionKey(ts.URL+"/a", 10, "")] == nil {
+		t.Errorf("loaded %d subscriptions", len(loaded.entries))
+	}
+
+	if err := ioutil.WriteFile(file, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newSubscriptionRegistry(file, time.Hour, time.Hour).Load(); err == nil {
+		t.Error("loaded a broken file")
+	}
+	if err := newSubscriptionRegistry(file+".none", time.Hour, time.Hour).Load(); err != nil {
+		t.Error(err)
+	}
+}
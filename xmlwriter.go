@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+// xmlWriter streams an XML document with encoding/xml. All text and
+// attribute values are escaped, and the characters which are not
+// allowed in XML 1.0 are stripped.
+type xmlWriter struct {
+	e   *xml.Encoder
+	err error
+}
+
+func newXMLWriter(w io.Writer) *xmlWriter {
+	return &xmlWriter{e: xml.NewEncoder(w)}
+}
+
+func (x *xmlWriter) token(t xml.Token) {
+	if x.err == nil {
+		x.err = x.e.EncodeToken(t)
+	}
+}
+
+// procInst writes a processing instruction, such as <?xml ...?>.
+func (x *xmlWriter) procInst(target, inst string) {
+	x.token(xml.ProcInst{Target: target, Inst: []byte(inst)})
+}
+
+// start writes a start element, attrs is a list of name and value pairs.
+func (x *xmlWriter) start(name string, attrs ...string) {
+	el := xml.StartElement{Name: xml.Name{Local: name}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		el.Attr = append(el.Attr, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: xmlText(attrs[i+1])})
+	}
+	x.token(el)
+}
+
+func (x *xmlWriter) end(name string) {
+	x.token(xml.EndElement{Name: xml.Name{Local: name}})
+}
+
+func (x *xmlWriter) text(s string) {
+	x.token(xml.CharData(xmlText(s)))
+}
+
+// element writes <name attrs...>text</name>.
+func (x *xmlWriter) element(name, text string, attrs ...string) {
+	x.start(name, attrs...)
+	x.text(text)
+	x.end(name)
+}
+
+// elementIf writes the element only if text is not empty.
+func (x *xmlWriter) elementIf(name, text string, attrs ...string) {
+	if text != "" {
+		x.element(name, text, attrs...)
+	}
+}
+
+// node writes an element of the source feed as it is.
+func (x *xmlWriter) node(n *xmlquery.Node) {
+	switch n.Type {
+	case xmlquery.ElementNode:
+		name := n.Data
+		if n.Prefix != "" {
+			name = n.Prefix + ":" + name
+		}
+		var attrs []string
+		for _, attr := range n.Attr {
+			switch space := attr.Name.Space; {
+			case space == "":
+				attrs = append(attrs, attr.Name.Local, attr.Value)
+			case space == "http://www.w3.org/XML/1998/namespace":
+				attrs = append(attrs, "xml:"+attr.Name.Local, attr.Value)
+			case !strings.Contains(space, ":"):
+				// the prefix of namespace
+				attrs = append(attrs, space+":"+attr.Name.Local, attr.Value)
+			}
+		}
+		x.start(name, attrs...)
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			x.node(child)
+		}
+		x.end(name)
+	case xmlquery.TextNode:
+		x.text(n.Data)
+	}
+}
+
+func (x *xmlWriter) flush() error {
+	if x.err == nil {
+		x.err = x.e.Flush()
+	}
+	return x.err
+}
+
+// xmlText strips the characters which are not allowed in XML 1.0.
+// https://www.w3.org/TR/xml/#charsets
+func xmlText(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+		case r < 0x20:
+			return -1
+		case r >= 0xD800 && r <= 0xDFFF:
+			return -1
+		case r == 0xFFFE || r == 0xFFFF:
+			return -1
+		}
+		return r
+	}, s)
+}